@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"flag"
 	"net/http"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -12,8 +14,12 @@ import (
 	"taskqueue/internal/database"
 	"taskqueue/internal/handlers"
 	"taskqueue/internal/middleware"
+	"taskqueue/internal/outbox"
 	"taskqueue/internal/queue"
+	"taskqueue/internal/reaper"
+	"taskqueue/internal/scheduler"
 	ws "taskqueue/internal/websocket"
+	"taskqueue/internal/worker"
 	"taskqueue/pkg/logger"
 )
 
@@ -24,10 +30,34 @@ var upgrader = websocket.Upgrader{
 }
 
 func main() {
+	dev := flag.Bool("dev", false, "development mode: in-process memory queue, no AWS required")
+	flag.Parse()
+
 	cfg := config.Load()
+	ctx := context.Background()
+
+	if flag.Arg(0) == "migrate" {
+		db, err := database.Connect(ctx, cfg.DatabaseURL)
+		if err != nil {
+			logger.Error("db connect:", err)
+			os.Exit(1)
+		}
+		err = runMigrate(ctx, db, flag.Args()[1:])
+		db.Close()
+		if err != nil {
+			logger.Error("migrate:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *dev {
+		cfg.QueueBackend = "memory"
+		logger.Info("dev mode: using in-memory queue backend")
+	}
 
 	// Set Gin mode
-	if cfg.Port == "8080" {
+	if *dev || cfg.Port == "8080" {
 		gin.SetMode(gin.DebugMode)
 	} else {
 		gin.SetMode(gin.ReleaseMode)
@@ -43,8 +73,6 @@ func main() {
 	r.LoadHTMLGlob("web/templates/**/*.html")
 	r.Static("/static", "web/static")
 
-	ctx := context.Background()
-
 	// Initialize database
 	db, err := database.Connect(ctx, cfg.DatabaseURL)
 	if err != nil {
@@ -53,32 +81,129 @@ func main() {
 	}
 	defer db.Close()
 
-	// Initialize SQS
-	q, err := queue.New(ctx, cfg.AWSRegion, cfg.SQSQueueURL)
+	// Migrations are applied with "main migrate up"; dev mode applies them
+	// itself for convenience.
+	if *dev {
+		if _, err := database.Migrate(ctx, db); err != nil {
+			logger.Error("migrate:", err)
+			return
+		}
+	} else if pending, err := database.PendingMigrations(ctx, db); err != nil {
+		logger.Error("migration status:", err)
+		return
+	} else if pending > 0 {
+		logger.Error("database has", pending, "pending migrations; run \"main migrate up\"")
+		return
+	}
+
+	// Initialize queue broker
+	q, err := queue.Open(ctx, cfg, db)
 	if err != nil {
-		logger.Error("sqs:", err)
+		logger.Error("queue:", err)
 		return
 	}
 
-	// Initialize WebSocket hub
+	// In dev mode nothing outside this process can reach the memory queue,
+	// so run the Go worker in-process.
+	if *dev {
+		registry := worker.NewRegistry()
+		worker.RegisterBuiltins(registry)
+		w := worker.New("dev-worker", q, database.NewPgStore(db), registry, cfg.WorkerConcurrency)
+		go w.Run(ctx)
+	}
+
+	// Initialize WebSocket hub, bridged to the other replicas
 	hub := ws.NewHub()
+	hub.Bridge, err = ws.OpenBridge(cfg.HubBridge, db)
+	if err != nil {
+		logger.Error("hub bridge:", err)
+		return
+	}
+	// Record events so reconnecting clients can catch up
+	events := ws.NewPostgresEventStore(db, cfg.EventRetention)
+	hub.Events = events
+	go events.Run(ctx)
 	go hub.Run()
+	go hub.RunBridge(ctx)
+
+	// Forward task status changes, whichever process made them, to clients
+	listener := &ws.TaskStatusListener{DB: db, Hub: hub}
+	go listener.Run(ctx)
+
+	// Publish outbox rows to the queue
+	relay := outbox.NewRelay(db, q, cfg.OutboxInterval)
+	go relay.Run(ctx)
+
+	// Take back tasks from workers that crashed or hung, or ran past their
+	// timeout, telling the owner
+	reap := reaper.New(db, cfg.ReaperInterval, cfg.ReaperHeartbeatTimeout)
+	reap.Reaped = func(st *database.StuckTask, status string) {
+		hub.Broadcast(&ws.Event{
+			UserID:     st.UserID,
+			Type:       "task_reaped",
+			TaskID:     st.ID,
+			WorkflowID: st.WorkflowID,
+			TaskType:   st.Type,
+			Data: gin.H{
+				"task_id":   st.ID,
+				"attempt":   st.Attempt,
+				"worker_id": st.WorkerID,
+				"reason":    st.Reason,
+				"status":    status,
+			},
+		})
+	}
+	go reap.Run(ctx)
 
 	// Initialize OAuth provider
 	oauthProvider := auth.NewGoogleOAuth(cfg.GoogleClientID, cfg.GoogleSecret, cfg.GoogleRedirect)
 
 	// Initialize handlers
+	// Task and user storage behind the handlers
+	store := database.NewPgStore(db)
+	
 	authHandler := &handlers.AuthHandler{
-		DB:            db,
+		Users:         store,
 		OAuthProvider: oauthProvider,
 		JWTSecret:     cfg.JWTSecret,
 	}
 	
 	taskHandler := &handlers.TaskHandler{
+		Tasks:          store,
+		Relay:          relay,
+		Hub:            hub,
+		IdempotencyTTL: cfg.IdempotencyTTL,
+		TaskTimeouts:   cfg.TaskTimeouts,
+	}
+	
+	// Turn due schedules into tasks through the same path as task creation
+	sched := scheduler.New(db, taskHandler.Submit, cfg.SchedulerInterval)
+	go sched.Run(ctx)
+	
+	scheduleHandler := &handlers.ScheduleHandler{DB: db}
+	
+	workflowHandler := &handlers.WorkflowHandler{
+		DB:           db,
+		Relay:        relay,
+		Hub:          hub,
+		TaskTimeouts: cfg.TaskTimeouts,
+	}
+	
+	adminHandler := &handlers.AdminHandler{
+		DB:               db,
+		Q:                q,
+		Relay:            relay,
+		WorkerStaleAfter: cfg.WorkerStaleAfter,
+		WorkerDeadAfter:  cfg.WorkerDeadAfter,
+	}
+	
+	workerHandler := &handlers.WorkerHandler{
 		DB:  db,
-		Q:   q,
 		Hub: hub,
 	}
+	if len(cfg.WorkerTokens) == 0 {
+		logger.Info("WORKER_TOKENS not set; the worker API will refuse every request")
+	}
 	
 	webHandler := &handlers.WebHandler{}
 
@@ -121,6 +246,26 @@ func main() {
 		})
 	}
 
+	// Task event stream; EventSource cannot send an Authorization header,
+	// so the session cookie is accepted as for the WebSocket
+	r.GET("/api/tasks/events", middleware.AuthRequired(cfg.JWTSecret), taskHandler.Events)
+
+	// Worker callbacks, authenticated with a worker token instead of a user
+	workerAPI := r.Group("/api/worker")
+	workerAPI.Use(middleware.WorkerAuthRequired(cfg.WorkerTokens))
+	{
+		workerAPI.POST("/register", workerHandler.Register)
+		workerAPI.POST("/heartbeat", workerHandler.WorkerHeartbeat)
+		workerAPI.POST("/tasks/:message_id/start", workerHandler.Start)
+		workerAPI.POST("/tasks/:message_id/dead-letter", workerHandler.DeadLetter)
+		workerAPI.POST("/tasks/:message_id/heartbeat", workerHandler.Heartbeat)
+		workerAPI.POST("/tasks/:message_id/progress", workerHandler.Progress)
+		workerAPI.POST("/tasks/:message_id/complete", workerHandler.Complete)
+		workerAPI.POST("/tasks/:message_id/fail", workerHandler.Fail)
+		workerAPI.POST("/tasks/:message_id/timeout", workerHandler.Timeout)
+		workerAPI.POST("/tasks/:message_id/cancel", workerHandler.Cancel)
+	}
+
 	// API routes
 	api := r.Group("/api")
 	api.Use(middleware.APIAuthRequired(cfg.JWTSecret))
@@ -133,6 +278,28 @@ func main() {
 		api.GET("/tasks/stats", taskHandler.Stats)
 		api.GET("/tasks/:id", taskHandler.Get)
 		api.DELETE("/tasks/:id", taskHandler.Cancel)
+		
+		// Schedule endpoints
+		api.POST("/schedules", scheduleHandler.Create)
+		api.GET("/schedules", scheduleHandler.List)
+		api.GET("/schedules/:id", scheduleHandler.Get)
+		api.DELETE("/schedules/:id", scheduleHandler.Delete)
+		
+		// Workflow endpoints
+		api.POST("/workflows", workflowHandler.Create)
+		api.GET("/workflows", workflowHandler.List)
+		api.GET("/workflows/:id", workflowHandler.Get)
+		
+		// Admin endpoints
+		admin := api.Group("/admin")
+		admin.Use(middleware.AdminRequired(cfg.AdminUserIDs))
+		admin.GET("/queue", adminHandler.QueueStats)
+		admin.GET("/workers", adminHandler.ListWorkers)
+		admin.GET("/dead-letters", adminHandler.ListDeadLetters)
+		admin.POST("/dead-letters/requeue", adminHandler.RequeueAllDeadLetters)
+		admin.POST("/dead-letters/:id/requeue", adminHandler.RequeueDeadLetter)
+		admin.DELETE("/dead-letters", adminHandler.PurgeDeadLetters)
+		admin.DELETE("/dead-letters/:id", adminHandler.PurgeDeadLetter)
 	}
 
 	logger.Info("starting server on", cfg.Port)
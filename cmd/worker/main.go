@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"taskqueue/internal/config"
+	"taskqueue/internal/database"
+	"taskqueue/internal/queue"
+	"taskqueue/internal/worker"
+	"taskqueue/pkg/logger"
+)
+
+func main() {
+	cfg := config.Load()
+
+	// The first signal stops polling and waits for in-flight tasks. Restoring
+	// the default handlers afterwards lets a second signal kill the process.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+		logger.Info("shutting down, waiting for in-flight tasks (signal again to force)")
+	}()
+
+	db, err := database.Connect(context.Background(), cfg.DatabaseURL)
+	if err != nil {
+		logger.Error("db connect:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	q, err := queue.Open(context.Background(), cfg, db)
+	if err != nil {
+		logger.Error("queue:", err)
+		os.Exit(1)
+	}
+
+	workerID := cfg.WorkerID
+	if workerID == "" {
+		workerID = fmt.Sprintf("go-worker-%d", os.Getpid())
+	}
+
+	registry := worker.NewRegistry()
+	worker.RegisterBuiltins(registry)
+
+	w := worker.New(workerID, q, database.NewPgStore(db), registry, cfg.WorkerConcurrency)
+	w.HeartbeatInterval = cfg.WorkerHeartbeatInterval
+	if err := w.Run(ctx); err != nil {
+		logger.Error("worker:", err)
+		os.Exit(1)
+	}
+}
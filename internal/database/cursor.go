@@ -0,0 +1,167 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"taskqueue/internal/models"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+// or was issued for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrInvalidSort is returned when a task list is sorted by an unknown field.
+var ErrInvalidSort = errors.New("invalid sort field")
+
+// Fields tasks can be sorted by.
+const (
+	SortCreatedAt   = "created_at"
+	SortUpdatedAt   = "updated_at"
+	SortCompletedAt = "completed_at"
+	SortName        = "name"
+)
+
+// taskSortExprs maps each sort field to the expression ordered on. Tasks
+// that have not completed sort as the zero time, as in models.Task.
+var taskSortExprs = map[string]string{
+	SortCreatedAt:   "created_at",
+	SortUpdatedAt:   "updated_at",
+	SortCompletedAt: "COALESCE(completed_at, '0001-01-01'::timestamp)",
+	SortName:        "name",
+}
+
+// Cursor is a position in a task list ordered by a sort field and then ID.
+// A cursor with Prev set pages back towards the start of the list.
+type Cursor struct {
+	Sort string    `json:"o"`
+	Asc  bool      `json:"a,omitempty"`
+	Time time.Time `json:"t"`
+	Text string    `json:"s,omitempty"`
+	ID   int64     `json:"id"`
+	Prev bool      `json:"p,omitempty"`
+}
+
+// Encode returns the cursor as an opaque URL-safe string.
+func (c *Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses a string returned by Cursor.Encode.
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	if _, ok := taskSortExprs[c.Sort]; !ok {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// key returns the sort value the cursor is positioned at.
+func (c *Cursor) key() interface{} {
+	if c.Sort == SortName {
+		return c.Text
+	}
+	return c.Time
+}
+
+// task returns a task holding the cursor's sort value and ID, for comparing
+// with other tasks.
+func (c *Cursor) task() *models.Task {
+	t := &models.Task{ID: c.ID}
+	switch c.Sort {
+	case SortUpdatedAt:
+		t.UpdatedAt = c.Time
+	case SortCompletedAt:
+		t.CompletedAt = c.Time
+	case SortName:
+		t.Name = c.Text
+	default:
+		t.CreatedAt = c.Time
+	}
+	return t
+}
+
+// sortField returns the field the filter sorts by, created_at by default.
+func (f *TaskFilter) sortField() string {
+	if f.Sort == "" {
+		return SortCreatedAt
+	}
+	return f.Sort
+}
+
+// check reports whether the filter's sort field is known and its cursor
+// belongs to the same order.
+func (f *TaskFilter) check() error {
+	if _, ok := taskSortExprs[f.sortField()]; !ok {
+		return ErrInvalidSort
+	}
+	if f.Cursor != nil && (f.Cursor.Sort != f.sortField() || f.Cursor.Asc != f.Asc) {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// cursorAt returns a cursor positioned at t in the filter's order.
+func (f *TaskFilter) cursorAt(t *models.Task, prev bool) string {
+	c := &Cursor{Sort: f.sortField(), Asc: f.Asc, ID: t.ID, Prev: prev}
+	switch c.Sort {
+	case SortUpdatedAt:
+		c.Time = t.UpdatedAt
+	case SortCompletedAt:
+		c.Time = t.CompletedAt
+	case SortName:
+		c.Text = t.Name
+	default:
+		c.Time = t.CreatedAt
+	}
+	return c.Encode()
+}
+
+// TaskPage is one page of tasks in the requested order. NextCursor continues
+// further down the list and PrevCursor back towards its start; each is empty
+// when there is nothing more in that direction.
+type TaskPage struct {
+	Tasks      []models.Task `json:"tasks"`
+	NextCursor string        `json:"next_cursor"`
+	PrevCursor string        `json:"prev_cursor"`
+}
+
+// newTaskPage builds a page from up to limit+1 tasks in list order, fetched
+// in the direction of the filter's cursor; the extra task only signals that
+// more exist.
+func newTaskPage(tasks []models.Task, filter *TaskFilter) *TaskPage {
+	cursor := filter.Cursor
+	more := filter.Limit > 0 && len(tasks) > filter.Limit
+	if more {
+		if cursor != nil && cursor.Prev {
+			tasks = tasks[1:]
+		} else {
+			tasks = tasks[:filter.Limit]
+		}
+	}
+
+	page := &TaskPage{Tasks: tasks}
+	if len(tasks) == 0 {
+		return page
+	}
+
+	// Paging back always leaves later rows behind, and paging forward from
+	// a cursor always leaves earlier rows behind.
+	if more || (cursor != nil && cursor.Prev) {
+		page.NextCursor = filter.cursorAt(&tasks[len(tasks)-1], false)
+	}
+	if cursor != nil && (!cursor.Prev || more) {
+		page.PrevCursor = filter.cursorAt(&tasks[0], true)
+	}
+	return page
+}
@@ -2,56 +2,37 @@ package database
 
 import (
 	"context"
-	"embed"
 	"fmt"
-	"strings"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"taskqueue/internal/models"
 )
 
-//go:embed migrations/*.sql
-var migrations embed.FS
+// querier is satisfied by both *pgxpool.Pool and pgx.Tx.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
 
-// Connect creates a new pgx connection pool.
+// Connect creates a new pgx connection pool. It does not migrate the
+// schema; see Migrate.
 func Connect(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
 	pool, err := pgxpool.New(ctx, dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := runMigrations(ctx, pool); err != nil {
+	if err := pool.Ping(ctx); err != nil {
 		pool.Close()
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
+		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
 
 	return pool, nil
 }
 
-func runMigrations(ctx context.Context, pool *pgxpool.Pool) error {
-	files, err := migrations.ReadDir("migrations")
-	if err != nil {
-		return err
-	}
-
-	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".sql") {
-			continue
-		}
-
-		content, err := migrations.ReadFile("migrations/" + file.Name())
-		if err != nil {
-			return fmt.Errorf("failed to read migration %s: %w", file.Name(), err)
-		}
-
-		if _, err := pool.Exec(ctx, string(content)); err != nil {
-			return fmt.Errorf("failed to execute migration %s: %w", file.Name(), err)
-		}
-	}
-
-	return nil
-}
-
 // User database operations
 func CreateUser(ctx context.Context, db *pgxpool.Pool, googleID, email, name, picture string) (int64, error) {
 	var userID int64
@@ -76,3 +57,17 @@ func GetUserByGoogleID(ctx context.Context, db *pgxpool.Pool, googleID string) (
 	}
 	return userID, err
 }
+
+// GetUser returns a user by ID
+func GetUser(ctx context.Context, db *pgxpool.Pool, userID int64) (*models.User, error) {
+	var u models.User
+	err := db.QueryRow(ctx, `
+		SELECT id, google_id, email, name, COALESCE(picture, ''), created_at, updated_at
+		FROM users WHERE id = $1`, userID).Scan(
+		&u.ID, &u.GoogleID, &u.Email, &u.Name, &u.Picture, &u.CreatedAt, &u.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
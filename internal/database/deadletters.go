@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"taskqueue/internal/models"
+)
+
+// ErrDeadLetterNotFound is returned when a dead letter ID does not exist.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// ErrDeadLetterNoTask is returned when requeueing a dead letter that has no
+// task, such as a malformed message, which would only be dead-lettered again.
+var ErrDeadLetterNoTask = errors.New("dead letter has no task to requeue")
+
+// InsertDeadLetter records a message that cannot be processed. TaskID may be
+// zero for messages that could not be matched to a task.
+func InsertDeadLetter(ctx context.Context, db *pgxpool.Pool, dl *models.DeadLetter) error {
+	return insertDeadLetter(ctx, db, dl)
+}
+
+func insertDeadLetter(ctx context.Context, q querier, dl *models.DeadLetter) error {
+	return q.QueryRow(ctx, `
+		INSERT INTO dead_letters (task_id, message_id, body, reason, error_message, attempts)
+		VALUES (NULLIF($1, 0), NULLIF($2, ''), NULLIF($3, ''), $4, $5, $6)
+		RETURNING id, created_at`,
+		dl.TaskID, dl.MessageID, dl.Body, dl.Reason, dl.Error, dl.Attempts,
+	).Scan(&dl.ID, &dl.CreatedAt)
+}
+
+// ListDeadLetters returns dead letters, newest first, with their task's name and type
+func ListDeadLetters(ctx context.Context, db *pgxpool.Pool, limit, offset int) ([]models.DeadLetter, error) {
+	rows, err := db.Query(ctx, `
+		SELECT d.id, COALESCE(d.task_id, 0), COALESCE(t.name, ''), COALESCE(t.type, ''),
+		COALESCE(d.message_id, ''), COALESCE(d.body, ''), d.reason,
+		COALESCE(d.error_message, ''), d.attempts, d.created_at
+		FROM dead_letters d LEFT JOIN tasks t ON t.id = d.task_id
+		ORDER BY d.created_at DESC, d.id DESC
+		LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := []models.DeadLetter{}
+	for rows.Next() {
+		var dl models.DeadLetter
+		if err := rows.Scan(&dl.ID, &dl.TaskID, &dl.TaskName, &dl.TaskType,
+			&dl.MessageID, &dl.Body, &dl.Reason, &dl.Error, &dl.Attempts,
+			&dl.CreatedAt); err != nil {
+			return nil, err
+		}
+		letters = append(letters, dl)
+	}
+	return letters, rows.Err()
+}
+
+// CountDeadLetters returns the number of dead letters
+func CountDeadLetters(ctx context.Context, db *pgxpool.Pool) (int64, error) {
+	var n int64
+	err := db.QueryRow(ctx, "SELECT COUNT(*) FROM dead_letters").Scan(&n)
+	return n, err
+}
+
+// RequeueDeadLetter removes a task's dead letter and runs the task again:
+// the task is reset to pending with one more attempt, workflow tasks it
+// skipped return to blocked, and it is scheduled through the outbox. It
+// returns ErrDeadLetterNoTask for a dead letter without a task.
+func RequeueDeadLetter(ctx context.Context, db *pgxpool.Pool, id int64) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		var taskID int64
+		err := tx.QueryRow(ctx, `
+			SELECT COALESCE(task_id, 0) FROM dead_letters
+			WHERE id=$1 FOR UPDATE`, id).Scan(&taskID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrDeadLetterNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if taskID == 0 {
+			return ErrDeadLetterNoTask
+		}
+
+		result, err := tx.Exec(ctx, `
+			UPDATE tasks SET status='pending', max_attempts=attempt+1,
+			       error_message=NULL, completed_at=NULL
+			WHERE id=$1 AND status='failed'`, taskID)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return fmt.Errorf("task %d is no longer failed", taskID)
+		}
+		if err := unskipDependents(ctx, tx, taskID); err != nil {
+			return err
+		}
+		if err := insertOutbox(ctx, tx, taskID, 0); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "DELETE FROM dead_letters WHERE id=$1", id)
+		return err
+	})
+}
+
+// RequeueAllDeadLetters requeues every dead letter that has a task, stopping
+// at the first error. It returns how many were requeued.
+func RequeueAllDeadLetters(ctx context.Context, db *pgxpool.Pool) (int, error) {
+	rows, err := db.Query(ctx, "SELECT id FROM dead_letters WHERE task_id IS NOT NULL ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range ids {
+		err := RequeueDeadLetter(ctx, db, id)
+		if errors.Is(err, ErrDeadLetterNotFound) {
+			continue // purged or requeued concurrently
+		}
+		if err != nil {
+			return n, fmt.Errorf("dead letter %d: %w", id, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// PurgeDeadLetter deletes a single dead letter
+func PurgeDeadLetter(ctx context.Context, db *pgxpool.Pool, id int64) error {
+	result, err := db.Exec(ctx, "DELETE FROM dead_letters WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+// PurgeDeadLetters deletes every dead letter and returns how many were removed
+func PurgeDeadLetters(ctx context.Context, db *pgxpool.Pool) (int64, error) {
+	result, err := db.Exec(ctx, "DELETE FROM dead_letters")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TaskEvent is an event sent to a user's WebSocket clients, recorded so
+// that reconnecting clients can replay the events they missed.
+type TaskEvent struct {
+	ID         int64
+	UserID     int64
+	Type       string
+	TaskID     int64
+	WorkflowID int64
+	TaskType   string
+	Data       []byte
+	CreatedAt  time.Time
+}
+
+// AppendTaskEvent records an event and sets its ID and CreatedAt.
+func AppendTaskEvent(ctx context.Context, db *pgxpool.Pool, e *TaskEvent) error {
+	return db.QueryRow(ctx, `
+		INSERT INTO task_events (user_id, type, task_id, workflow_id, task_type, data)
+		VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, 0), NULLIF($5, ''), $6)
+		RETURNING id, created_at`,
+		e.UserID, e.Type, e.TaskID, e.WorkflowID, e.TaskType, e.Data,
+	).Scan(&e.ID, &e.CreatedAt)
+}
+
+// GetTaskEvent returns the event with id, or pgx.ErrNoRows if it is not
+// recorded.
+func GetTaskEvent(ctx context.Context, db *pgxpool.Pool, id int64) (*TaskEvent, error) {
+	var e TaskEvent
+	err := db.QueryRow(ctx, `
+		SELECT id, user_id, type, COALESCE(task_id, 0), COALESCE(workflow_id, 0),
+		       COALESCE(task_type, ''), data, created_at
+		FROM task_events WHERE id = $1`, id).Scan(
+		&e.ID, &e.UserID, &e.Type, &e.TaskID, &e.WorkflowID,
+		&e.TaskType, &e.Data, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ListTaskEventsSince returns up to limit of a user's events with IDs after
+// afterID, oldest first, together with the user's events with IDs up to
+// afterID that were recorded no more than window before event afterID.
+//
+// IDs are taken when an event is written but become visible when its
+// transaction commits, so an event can commit after one with a higher ID.
+// Looking back over window finds those whose transaction took less than
+// window; callers may already have some of them.
+func ListTaskEventsSince(ctx context.Context, db *pgxpool.Pool, userID, afterID int64, window time.Duration, limit int) ([]TaskEvent, error) {
+	rows, err := db.Query(ctx, `
+		SELECT id, user_id, type, COALESCE(task_id, 0), COALESCE(workflow_id, 0),
+		       COALESCE(task_type, ''), data, created_at
+		FROM task_events
+		WHERE user_id = $1 AND id > $2
+		UNION ALL
+		SELECT e.id, e.user_id, e.type, COALESCE(e.task_id, 0), COALESCE(e.workflow_id, 0),
+		       COALESCE(e.task_type, ''), e.data, e.created_at
+		FROM task_events e, task_events a
+		WHERE a.id = $2 AND e.user_id = $1 AND e.id <= $2
+		  AND e.created_at >= a.created_at - make_interval(secs => $3)
+		ORDER BY id
+		LIMIT $4`, userID, afterID, window.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []TaskEvent{}
+	for rows.Next() {
+		var e TaskEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Type, &e.TaskID, &e.WorkflowID,
+			&e.TaskType, &e.Data, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// OldestTaskEventID returns the ID of the oldest event still recorded, or 0
+// if there are none.
+func OldestTaskEventID(ctx context.Context, db *pgxpool.Pool) (int64, error) {
+	var id int64
+	err := db.QueryRow(ctx, "SELECT COALESCE(MIN(id), 0) FROM task_events").Scan(&id)
+	return id, err
+}
+
+// PruneTaskEvents deletes events recorded more than olderThan ago and
+// returns how many were deleted.
+func PruneTaskEvents(ctx context.Context, db *pgxpool.Pool, olderThan time.Duration) (int64, error) {
+	tag, err := db.Exec(ctx, `
+		DELETE FROM task_events
+		WHERE created_at < CURRENT_TIMESTAMP - make_interval(secs => $1)`, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"taskqueue/internal/models"
+)
+
+var (
+	// ErrIdempotencyKeyNotFound is returned when a user has no unexpired
+	// record of an idempotency key.
+	ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+
+	// ErrIdempotencyKeyExists is returned when another request stored the
+	// same idempotency key first.
+	ErrIdempotencyKeyExists = errors.New("idempotency key already used")
+)
+
+// IdempotencyKey records the task created for a request carrying an
+// Idempotency-Key header, so a retry of the request can be answered with it.
+type IdempotencyKey struct {
+	UserID      int64
+	Key         string
+	RequestHash string
+	TaskID      int64
+	StatusCode  int
+	TTL         time.Duration
+}
+
+// GetIdempotencyKey returns a user's unexpired record of key.
+func GetIdempotencyKey(ctx context.Context, db *pgxpool.Pool, userID int64, key string) (*IdempotencyKey, error) {
+	k := IdempotencyKey{UserID: userID, Key: key}
+	err := db.QueryRow(ctx, `
+		SELECT request_hash, task_id, status_code FROM idempotency_keys
+		WHERE user_id=$1 AND key=$2 AND expires_at > CURRENT_TIMESTAMP`,
+		userID, key).Scan(&k.RequestHash, &k.TaskID, &k.StatusCode)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// CreateTaskWithIdempotencyKey is CreateTaskWithOutbox that also stores k
+// for the new task in the same transaction. If the user already has an
+// unexpired record of the key nothing is written and ErrIdempotencyKeyExists
+// is returned. The user's expired keys are removed on the way.
+func CreateTaskWithIdempotencyKey(ctx context.Context, db *pgxpool.Pool, t *models.Task, k *IdempotencyKey) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			DELETE FROM idempotency_keys
+			WHERE user_id=$1 AND expires_at <= CURRENT_TIMESTAMP`, k.UserID); err != nil {
+			return err
+		}
+
+		if err := insertTask(ctx, tx, t); err != nil {
+			return err
+		}
+		k.TaskID = t.ID
+
+		// A concurrent request with the same key blocks here until the
+		// first commits, then inserts nothing.
+		result, err := tx.Exec(ctx, `
+			INSERT INTO idempotency_keys (user_id, key, request_hash, task_id, status_code, expires_at)
+			VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP + make_interval(secs => $6))
+			ON CONFLICT (user_id, key) DO NOTHING`,
+			k.UserID, k.Key, k.RequestHash, k.TaskID, k.StatusCode, k.TTL.Seconds())
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrIdempotencyKeyExists
+		}
+
+		return insertOutbox(ctx, tx, t.ID, 0)
+	})
+}
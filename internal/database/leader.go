@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Leader holds a session-level advisory lock on a dedicated connection. Only
+// one session can hold a given key, so it elects a single leader among
+// replicas sharing a database; the lock is released when the connection
+// closes, letting another replica take over.
+type Leader struct {
+	conn *pgxpool.Conn
+	key  int64
+}
+
+// TryLead attempts to take the advisory lock for key without waiting. It
+// returns nil if another session holds it.
+func TryLead(ctx context.Context, db *pgxpool.Pool, key int64) (*Leader, error) {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		conn.Release()
+		return nil, err
+	}
+	if !locked {
+		conn.Release()
+		return nil, nil
+	}
+	return &Leader{conn: conn, key: key}, nil
+}
+
+// Check returns an error if the connection holding the lock has been lost,
+// after which the lock may be held by another session.
+func (l *Leader) Check(ctx context.Context) error {
+	return l.conn.Ping(ctx)
+}
+
+// Release gives up the lock and returns the connection to the pool.
+func (l *Leader) Release(ctx context.Context) {
+	if _, err := l.conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		// The session state is unknown; close the connection so the lock
+		// cannot outlive it.
+		l.conn.Conn().Close(ctx)
+	}
+	l.conn.Release()
+}
@@ -0,0 +1,494 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"taskqueue/internal/models"
+)
+
+// MemoryStore implements TaskStore, UserStore and WorkerStore in memory, for
+// running handlers and workers without Postgres. Tasks are stored but never
+// published to a queue, have no attempt history, and do not release or skip
+// workflow dependents. Retried tasks are left retrying.
+type MemoryStore struct {
+	mu          sync.Mutex
+	tasks       map[int64]*models.Task
+	users       map[int64]*models.User
+	keys        map[memoryKey]*memoryIdempotencyKey
+	workers     map[string]*models.Worker
+	deadLetters []models.DeadLetter
+	nextTask    int64
+	nextUser    int64
+}
+
+type memoryKey struct {
+	userID int64
+	key    string
+}
+
+type memoryIdempotencyKey struct {
+	IdempotencyKey
+	expiresAt time.Time
+}
+
+var (
+	_ TaskStore   = (*MemoryStore)(nil)
+	_ UserStore   = (*MemoryStore)(nil)
+	_ WorkerStore = (*MemoryStore)(nil)
+)
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tasks:   make(map[int64]*models.Task),
+		users:   make(map[int64]*models.User),
+		keys:    make(map[memoryKey]*memoryIdempotencyKey),
+		workers: make(map[string]*models.Worker),
+	}
+}
+
+func (s *MemoryStore) CreateTask(ctx context.Context, t *models.Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insertTask(t)
+	return nil
+}
+
+func (s *MemoryStore) insertTask(t *models.Task) {
+	s.nextTask++
+	now := time.Now().UTC()
+	t.ID, t.CreatedAt, t.UpdatedAt = s.nextTask, now, now
+	stored := *t
+	s.tasks[t.ID] = &stored
+}
+
+func (s *MemoryStore) CreateTaskWithIdempotencyKey(ctx context.Context, t *models.Task, k *IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mk := memoryKey{k.UserID, k.Key}
+	if prior, ok := s.keys[mk]; ok && time.Now().Before(prior.expiresAt) {
+		return ErrIdempotencyKeyExists
+	}
+
+	s.insertTask(t)
+	k.TaskID = t.ID
+	s.keys[mk] = &memoryIdempotencyKey{IdempotencyKey: *k, expiresAt: time.Now().Add(k.TTL)}
+	return nil
+}
+
+func (s *MemoryStore) GetIdempotencyKey(ctx context.Context, userID int64, key string) (*IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prior, ok := s.keys[memoryKey{userID, key}]
+	if !ok || !time.Now().Before(prior.expiresAt) {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+	k := prior.IdempotencyKey
+	return &k, nil
+}
+
+func (s *MemoryStore) GetTask(ctx context.Context, taskID, userID int64) (*models.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[taskID]
+	if !ok || t.UserID != userID {
+		return nil, pgx.ErrNoRows
+	}
+	task := *t
+	return &task, nil
+}
+
+func (s *MemoryStore) ListTasks(ctx context.Context, userID int64, filter *TaskFilter) (*TaskPage, error) {
+	if err := filter.check(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// before reports whether a comes before b in the filter's order.
+	before := func(a, b *models.Task) bool {
+		c := compareTasks(a, b, filter.sortField())
+		if filter.Asc {
+			return c < 0
+		}
+		return c > 0
+	}
+
+	cursor := filter.Cursor
+	var at *models.Task
+	if cursor != nil {
+		at = cursor.task()
+	}
+
+	tasks := []models.Task{}
+	for _, t := range s.tasks {
+		if t.UserID != userID || !matchTask(t, filter) {
+			continue
+		}
+		if at != nil && ((cursor.Prev && !before(t, at)) || (!cursor.Prev && !before(at, t))) {
+			continue
+		}
+		tasks = append(tasks, *t)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return before(&tasks[i], &tasks[j]) })
+
+	// Keep the limit+1 tasks nearest the cursor, as the SQL query does.
+	if n := filter.Limit + 1; filter.Limit > 0 && len(tasks) > n {
+		if cursor != nil && cursor.Prev {
+			tasks = tasks[len(tasks)-n:]
+		} else {
+			tasks = tasks[:n]
+		}
+	}
+	return newTaskPage(tasks, filter), nil
+}
+
+// compareTasks orders a and b by field and then ID, ascending.
+func compareTasks(a, b *models.Task, field string) int {
+	var c int
+	switch field {
+	case SortUpdatedAt:
+		c = a.UpdatedAt.Compare(b.UpdatedAt)
+	case SortCompletedAt:
+		c = a.CompletedAt.Compare(b.CompletedAt)
+	case SortName:
+		c = strings.Compare(a.Name, b.Name)
+	default:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c == 0 {
+		c = cmp.Compare(a.ID, b.ID)
+	}
+	return c
+}
+
+// matchTask reports whether t passes filter. Search is approximated by
+// requiring every word to appear in the name or error message.
+func matchTask(t *models.Task, filter *TaskFilter) bool {
+	if (filter.Status != "" && t.Status != filter.Status) ||
+		(filter.Type != "" && t.Type != filter.Type) ||
+		(filter.Priority != "" && t.Priority != filter.Priority) {
+		return false
+	}
+	if (!filter.CreatedAfter.IsZero() && t.CreatedAt.Before(filter.CreatedAfter)) ||
+		(!filter.CreatedBefore.IsZero() && !t.CreatedAt.Before(filter.CreatedBefore)) {
+		return false
+	}
+	if (!filter.CompletedAfter.IsZero() || !filter.CompletedBefore.IsZero()) && t.CompletedAt.IsZero() {
+		return false
+	}
+	if (!filter.CompletedAfter.IsZero() && t.CompletedAt.Before(filter.CompletedAfter)) ||
+		(!filter.CompletedBefore.IsZero() && !t.CompletedAt.Before(filter.CompletedBefore)) {
+		return false
+	}
+	text := strings.ToLower(t.Name + " " + t.Error)
+	for _, word := range strings.Fields(strings.ToLower(filter.Search)) {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return jsonContains(t.Payload, filter.Payload) && jsonContains(t.Result, filter.Result)
+}
+
+// jsonContains reports whether the JSON document doc contains sub, as the
+// Postgres jsonb @> operator does. An empty sub matches everything.
+func jsonContains(doc, sub []byte) bool {
+	if len(sub) == 0 {
+		return true
+	}
+	var d, v interface{}
+	if json.Unmarshal(doc, &d) != nil || json.Unmarshal(sub, &v) != nil {
+		return false
+	}
+	return valueContains(d, v)
+}
+
+func valueContains(doc, sub interface{}) bool {
+	switch sub := sub.(type) {
+	case map[string]interface{}:
+		d, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range sub {
+			if dv, ok := d[k]; !ok || !valueContains(dv, v) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		d, ok := doc.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, v := range sub {
+			if !slices.ContainsFunc(d, func(dv interface{}) bool { return valueContains(dv, v) }) {
+				return false
+			}
+		}
+		return true
+	default:
+		return doc == sub
+	}
+}
+
+func (s *MemoryStore) ListTaskAttempts(ctx context.Context, taskID int64) ([]models.TaskAttempt, error) {
+	return []models.TaskAttempt{}, nil
+}
+
+func (s *MemoryStore) CancelTask(ctx context.Context, taskID, userID int64) (*models.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[taskID]
+	if !ok || t.UserID != userID {
+		return nil, fmt.Errorf("task not found or cannot be cancelled")
+	}
+	now := time.Now().UTC()
+	switch t.Status {
+	case "pending", "blocked", "queued", "retrying":
+		t.Status, t.CompletedAt, t.UpdatedAt = "cancelled", now, now
+	case "processing":
+		t.CancelRequested, t.UpdatedAt = true, now
+	default:
+		return nil, fmt.Errorf("task not found or cannot be cancelled")
+	}
+	task := *t
+	return &task, nil
+}
+
+func (s *MemoryStore) GetTaskStats(ctx context.Context, userID int64) (*TaskStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stats TaskStats
+	for _, t := range s.tasks {
+		if t.UserID != userID {
+			continue
+		}
+		stats.Total++
+		switch t.Status {
+		case "pending":
+			stats.Pending++
+		case "blocked":
+			stats.Blocked++
+		case "queued":
+			stats.Queued++
+		case "processing":
+			stats.Processing++
+		case "retrying":
+			stats.Retrying++
+		case "completed":
+			stats.Completed++
+		case "failed":
+			stats.Failed++
+		case "cancelled":
+			stats.Cancelled++
+		case "skipped":
+			stats.Skipped++
+		case "timed_out":
+			stats.TimedOut++
+		}
+	}
+	return &stats, nil
+}
+
+func (s *MemoryStore) UpsertUser(ctx context.Context, googleID, email, name, picture string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, u := range s.users {
+		if u.GoogleID == googleID {
+			u.Email, u.Name, u.Picture, u.UpdatedAt = email, name, picture, now
+			return u.ID, nil
+		}
+	}
+
+	s.nextUser++
+	s.users[s.nextUser] = &models.User{
+		ID:        s.nextUser,
+		GoogleID:  googleID,
+		Email:     email,
+		Name:      name,
+		Picture:   picture,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	return s.nextUser, nil
+}
+
+func (s *MemoryStore) GetUser(ctx context.Context, userID int64) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	user := *u
+	return &user, nil
+}
+
+func (s *MemoryStore) RegisterWorker(ctx context.Context, w *models.Worker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	w.StartedAt, w.LastHeartbeatAt = now, now
+	stored := *w
+	s.workers[w.ID] = &stored
+	return nil
+}
+
+func (s *MemoryStore) WorkerHeartbeat(ctx context.Context, workerID string, currentTaskID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.workers[workerID]
+	if !ok {
+		return ErrWorkerNotFound
+	}
+	w.LastHeartbeatAt, w.CurrentTaskID = time.Now().UTC(), currentTaskID
+	return nil
+}
+
+func (s *MemoryStore) InsertDeadLetter(ctx context.Context, dl *models.DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insertDeadLetter(dl)
+	return nil
+}
+
+func (s *MemoryStore) insertDeadLetter(dl *models.DeadLetter) {
+	dl.ID, dl.CreatedAt = int64(len(s.deadLetters)+1), time.Now().UTC()
+	s.deadLetters = append(s.deadLetters, *dl)
+}
+
+// DeadLetters returns the dead letters recorded, oldest first.
+func (s *MemoryStore) DeadLetters() []models.DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.deadLetters)
+}
+
+// messageTask returns the task in status that carries messageID.
+func (s *MemoryStore) messageTask(messageID, status string) (*models.Task, error) {
+	for _, t := range s.tasks {
+		if t.MessageID == messageID && t.Status == status {
+			return t, nil
+		}
+	}
+	return nil, ErrStaleMessage
+}
+
+func (s *MemoryStore) UpdateTaskProgress(ctx context.Context, messageID, workerID string, taskID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.messageTask(messageID, "queued")
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	t.Status, t.WorkerID, t.StartedAt, t.UpdatedAt = "processing", workerID, now, now
+	t.Attempt++
+	return nil
+}
+
+func (s *MemoryStore) TaskHeartbeat(ctx context.Context, messageID string) (*models.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.messageTask(messageID, "processing")
+	if err != nil {
+		return nil, err
+	}
+	task := *t
+	return &task, nil
+}
+
+func (s *MemoryStore) CompleteTask(ctx context.Context, messageID string, result []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.messageTask(messageID, "processing")
+	if err != nil {
+		return err
+	}
+	s.finish(t, "completed", "")
+	t.Result = result
+	return nil
+}
+
+func (s *MemoryStore) FailTask(ctx context.Context, messageID, errorMsg string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.messageTask(messageID, "processing")
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case t.CancelRequested:
+		s.finish(t, "cancelled", errorMsg)
+	case t.Attempt < t.MaxAttempts:
+		t.Status, t.Error, t.UpdatedAt = "retrying", errorMsg, time.Now().UTC()
+	default:
+		s.finish(t, "failed", errorMsg)
+		s.insertDeadLetter(&models.DeadLetter{
+			TaskID:    t.ID,
+			MessageID: t.MessageID,
+			Reason:    "exhausted",
+			Error:     errorMsg,
+			Attempts:  t.Attempt,
+		})
+	}
+	return t.Status, nil
+}
+
+func (s *MemoryStore) TimeoutTask(ctx context.Context, messageID, errorMsg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.messageTask(messageID, "processing")
+	if err != nil {
+		return err
+	}
+	s.finish(t, "timed_out", errorMsg)
+	return nil
+}
+
+func (s *MemoryStore) CancelRunningTask(ctx context.Context, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.messageTask(messageID, "processing")
+	if err != nil {
+		return err
+	}
+	s.finish(t, "cancelled", "")
+	return nil
+}
+
+// finish ends t in status, recording errorMsg if it is not empty.
+func (s *MemoryStore) finish(t *models.Task, status, errorMsg string) {
+	now := time.Now().UTC()
+	t.Status, t.CompletedAt, t.UpdatedAt = status, now, now
+	if errorMsg != "" {
+		t.Error = errorMsg
+	}
+}
@@ -0,0 +1,18 @@
+-- Messages for the Postgres queue backend (QUEUE_BACKEND=postgres)
+CREATE TABLE IF NOT EXISTS queue_messages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    queue VARCHAR(100) NOT NULL,
+    body TEXT NOT NULL,
+    priority VARCHAR(20) NOT NULL DEFAULT 'medium',
+    priority_rank SMALLINT NOT NULL DEFAULT 1,
+    receipt_handle UUID,
+    receive_count INT NOT NULL DEFAULT 0,
+    visible_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Claim order: highest priority first, then oldest visible message
+CREATE INDEX IF NOT EXISTS idx_queue_messages_ready
+    ON queue_messages(queue, priority_rank DESC, visible_at, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_queue_messages_receipt
+    ON queue_messages(receipt_handle);
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS task_attempts;
+
+UPDATE tasks SET status='failed' WHERE status='retrying';
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_status_check;
+ALTER TABLE tasks ADD CONSTRAINT tasks_status_check
+    CHECK (status IN ('pending', 'queued', 'processing', 'completed', 'failed', 'cancelled'));
+
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_backoff_check;
+ALTER TABLE tasks DROP COLUMN IF EXISTS backoff_seconds;
+ALTER TABLE tasks DROP COLUMN IF EXISTS backoff;
+ALTER TABLE tasks DROP COLUMN IF EXISTS max_attempts;
+ALTER TABLE tasks DROP COLUMN IF EXISTS attempt;
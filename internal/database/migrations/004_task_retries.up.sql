@@ -0,0 +1,28 @@
+-- Retry policy and attempt counter
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS attempt INT NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS max_attempts INT NOT NULL DEFAULT 3;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS backoff VARCHAR(20) NOT NULL DEFAULT 'exponential';
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS backoff_seconds INT NOT NULL DEFAULT 10;
+
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_backoff_check;
+ALTER TABLE tasks ADD CONSTRAINT tasks_backoff_check
+    CHECK (backoff IN ('fixed', 'linear', 'exponential'));
+
+-- Failed tasks with attempts left wait in 'retrying' until re-enqueued
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_status_check;
+ALTER TABLE tasks ADD CONSTRAINT tasks_status_check
+    CHECK (status IN ('pending', 'queued', 'processing', 'retrying', 'completed', 'failed', 'cancelled'));
+
+-- One row per processing attempt
+CREATE TABLE IF NOT EXISTS task_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    task_id BIGINT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    attempt INT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'processing' CHECK (status IN ('processing', 'completed', 'failed')),
+    worker_id VARCHAR(255),
+    message_id VARCHAR(255),
+    error_message TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    UNIQUE (task_id, attempt)
+);
@@ -0,0 +1,14 @@
+-- Messages that exhausted their attempts or could not be parsed
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id BIGSERIAL PRIMARY KEY,
+    task_id BIGINT REFERENCES tasks(id) ON DELETE CASCADE,
+    message_id VARCHAR(255),
+    body TEXT,
+    reason VARCHAR(50) NOT NULL CHECK (reason IN ('exhausted', 'malformed')),
+    error_message TEXT,
+    attempts INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_created_at ON dead_letters(created_at);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_task_id ON dead_letters(task_id);
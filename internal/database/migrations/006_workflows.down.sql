@@ -0,0 +1,8 @@
+UPDATE tasks SET status='cancelled' WHERE status IN ('blocked', 'skipped');
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_status_check;
+ALTER TABLE tasks ADD CONSTRAINT tasks_status_check
+    CHECK (status IN ('pending', 'queued', 'processing', 'retrying', 'completed', 'failed', 'cancelled'));
+
+DROP TABLE IF EXISTS task_dependencies;
+ALTER TABLE tasks DROP COLUMN IF EXISTS workflow_id;
+DROP TABLE IF EXISTS workflows;
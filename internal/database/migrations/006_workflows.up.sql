@@ -0,0 +1,28 @@
+-- Workflows group tasks connected by dependency edges
+CREATE TABLE IF NOT EXISTS workflows (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_workflows_user_id ON workflows(user_id);
+
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS workflow_id BIGINT REFERENCES workflows(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_tasks_workflow_id ON tasks(workflow_id);
+
+-- task_id runs only after depends_on_id has completed
+CREATE TABLE IF NOT EXISTS task_dependencies (
+    task_id BIGINT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    depends_on_id BIGINT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    PRIMARY KEY (task_id, depends_on_id),
+    CHECK (task_id <> depends_on_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_dependencies_depends_on_id ON task_dependencies(depends_on_id);
+
+-- Tasks wait in 'blocked' until their parents complete, and are 'skipped'
+-- when a parent fails or is cancelled
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_status_check;
+ALTER TABLE tasks ADD CONSTRAINT tasks_status_check
+    CHECK (status IN ('pending', 'blocked', 'queued', 'processing', 'retrying', 'completed', 'failed', 'cancelled', 'skipped'));
@@ -0,0 +1,28 @@
+-- Schedules create tasks once at run_at, or repeatedly from a cron expression
+CREATE TABLE IF NOT EXISTS schedules (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    priority VARCHAR(20) NOT NULL CHECK (priority IN ('low', 'medium', 'high')),
+    payload JSONB,
+    max_attempts INT NOT NULL DEFAULT 3,
+    backoff VARCHAR(20) NOT NULL DEFAULT 'exponential' CHECK (backoff IN ('fixed', 'linear', 'exponential')),
+    backoff_seconds INT NOT NULL DEFAULT 10,
+    cron_expr VARCHAR(100),
+    run_at TIMESTAMP,
+    next_run_at TIMESTAMP,
+    last_run_at TIMESTAMP,
+    last_task_id BIGINT REFERENCES tasks(id) ON DELETE SET NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((cron_expr IS NULL) <> (run_at IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_schedules_user_id ON schedules(user_id);
+CREATE INDEX IF NOT EXISTS idx_schedules_next_run_at ON schedules(next_run_at) WHERE enabled;
+
+DROP TRIGGER IF EXISTS update_schedules_updated_at ON schedules;
+CREATE TRIGGER update_schedules_updated_at BEFORE UPDATE ON schedules
+    FOR EACH ROW EXECUTE PROCEDURE update_updated_at();
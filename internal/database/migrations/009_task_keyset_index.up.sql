@@ -0,0 +1,2 @@
+-- Serves keyset pagination of a user's tasks by (created_at, id).
+CREATE INDEX IF NOT EXISTS idx_tasks_user_created_id ON tasks(user_id, created_at DESC, id DESC);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_tasks_result;
+DROP INDEX IF EXISTS idx_tasks_payload;
+DROP INDEX IF EXISTS idx_tasks_search;
+DROP INDEX IF EXISTS idx_tasks_user_name_id;
+DROP INDEX IF EXISTS idx_tasks_user_completed_id;
+DROP INDEX IF EXISTS idx_tasks_user_completed_at;
+DROP INDEX IF EXISTS idx_tasks_user_updated_id;
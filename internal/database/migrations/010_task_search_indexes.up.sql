@@ -0,0 +1,15 @@
+-- Indexes for task search filters and sort orders. The expressions must
+-- match those used by ListTasks.
+CREATE INDEX IF NOT EXISTS idx_tasks_user_updated_id ON tasks(user_id, updated_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_tasks_user_completed_at ON tasks(user_id, completed_at);
+CREATE INDEX IF NOT EXISTS idx_tasks_user_completed_id
+    ON tasks(user_id, COALESCE(completed_at, '0001-01-01'::timestamp) DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_tasks_user_name_id ON tasks(user_id, name, id);
+
+-- Full-text search on name and error message
+CREATE INDEX IF NOT EXISTS idx_tasks_search
+    ON tasks USING GIN (to_tsvector('english', name || ' ' || COALESCE(error_message, '')));
+
+-- JSONB containment (@>) on payload and result
+CREATE INDEX IF NOT EXISTS idx_tasks_payload ON tasks USING GIN (payload jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS idx_tasks_result ON tasks USING GIN (result jsonb_path_ops);
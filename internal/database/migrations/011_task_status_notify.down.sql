@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS notify_task_status ON tasks;
+DROP FUNCTION IF EXISTS notify_task_status();
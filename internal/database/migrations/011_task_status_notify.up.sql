@@ -0,0 +1,23 @@
+-- Announce every task status change on the task_status channel, whichever
+-- process made it. Payloads stay small; listeners read the task for details.
+CREATE OR REPLACE FUNCTION notify_task_status() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('task_status', json_build_object(
+        'task_id', NEW.id,
+        'user_id', NEW.user_id,
+        'workflow_id', NEW.workflow_id,
+        'type', NEW.type,
+        'status', NEW.status,
+        'previous_status', OLD.status,
+        'attempt', NEW.attempt
+    )::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notify_task_status ON tasks;
+CREATE TRIGGER notify_task_status
+    AFTER UPDATE OF status ON tasks
+    FOR EACH ROW
+    WHEN (OLD.status IS DISTINCT FROM NEW.status)
+    EXECUTE FUNCTION notify_task_status();
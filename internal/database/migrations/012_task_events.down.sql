@@ -0,0 +1,17 @@
+-- Restore the notification-only trigger function of 011.
+CREATE OR REPLACE FUNCTION notify_task_status() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('task_status', json_build_object(
+        'task_id', NEW.id,
+        'user_id', NEW.user_id,
+        'workflow_id', NEW.workflow_id,
+        'type', NEW.type,
+        'status', NEW.status,
+        'previous_status', OLD.status,
+        'attempt', NEW.attempt
+    )::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TABLE IF EXISTS task_events;
@@ -0,0 +1,41 @@
+-- Events sent to WebSocket clients, kept so reconnecting clients can replay
+-- what they missed. IDs increase monotonically.
+CREATE TABLE IF NOT EXISTS task_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    task_id BIGINT,
+    workflow_id BIGINT,
+    task_type VARCHAR(100),
+    data JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_events_user_id ON task_events(user_id, id);
+CREATE INDEX IF NOT EXISTS idx_task_events_created_at ON task_events(created_at);
+
+-- Status changes are recorded as task_updated events, and the notification
+-- carries the event ID.
+CREATE OR REPLACE FUNCTION notify_task_status() RETURNS TRIGGER AS $$
+DECLARE
+    data JSONB;
+    event_id BIGINT;
+BEGIN
+    data := jsonb_build_object(
+        'task_id', NEW.id,
+        'user_id', NEW.user_id,
+        'workflow_id', NEW.workflow_id,
+        'type', NEW.type,
+        'status', NEW.status,
+        'previous_status', OLD.status,
+        'attempt', NEW.attempt
+    );
+
+    INSERT INTO task_events (user_id, type, task_id, workflow_id, task_type, data)
+    VALUES (NEW.user_id, 'task_updated', NEW.id, NEW.workflow_id, NEW.type, data)
+    RETURNING id INTO event_id;
+
+    PERFORM pg_notify('task_status', (data || jsonb_build_object('event_id', event_id))::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
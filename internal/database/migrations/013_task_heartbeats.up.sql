@@ -0,0 +1,3 @@
+-- Workers report that a processing task is still alive through the worker
+-- API; the time of the last report is kept on the task.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP;
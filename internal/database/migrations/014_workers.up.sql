@@ -0,0 +1,14 @@
+-- Workers register on startup and send heartbeats while they run. Their
+-- health is derived from the time since the last heartbeat.
+CREATE TABLE IF NOT EXISTS workers (
+    id VARCHAR(255) PRIMARY KEY,
+    language VARCHAR(50) NOT NULL DEFAULT '',
+    host VARCHAR(255) NOT NULL DEFAULT '',
+    task_types TEXT[] NOT NULL DEFAULT '{}',
+    version VARCHAR(100) NOT NULL DEFAULT '',
+    current_task_id BIGINT,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_heartbeat_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_workers_last_heartbeat_at ON workers(last_heartbeat_at);
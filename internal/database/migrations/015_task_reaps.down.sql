@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_tasks_processing_heartbeat;
+DROP TABLE IF EXISTS task_reaps;
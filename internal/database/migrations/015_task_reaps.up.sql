@@ -0,0 +1,17 @@
+-- Tasks the reaper took back from a worker that stopped sending heartbeats
+-- or ran past its type's timeout, with why and what became of the task.
+CREATE TABLE IF NOT EXISTS task_reaps (
+    id BIGSERIAL PRIMARY KEY,
+    task_id BIGINT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    attempt INT NOT NULL,
+    worker_id VARCHAR(255),
+    reason VARCHAR(50) NOT NULL CHECK (reason IN ('heartbeat_expired', 'timeout')),
+    status VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_reaps_task_id ON task_reaps(task_id);
+
+-- The reaper scans processing tasks by their last sign of life.
+CREATE INDEX IF NOT EXISTS idx_tasks_processing_heartbeat
+    ON tasks (COALESCE(heartbeat_at, started_at)) WHERE status = 'processing';
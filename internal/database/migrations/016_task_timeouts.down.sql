@@ -0,0 +1,12 @@
+UPDATE task_attempts SET status='failed' WHERE status='timed_out';
+ALTER TABLE task_attempts DROP CONSTRAINT IF EXISTS task_attempts_status_check;
+ALTER TABLE task_attempts ADD CONSTRAINT task_attempts_status_check
+    CHECK (status IN ('processing', 'completed', 'failed'));
+
+UPDATE tasks SET status='failed' WHERE status='timed_out';
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_status_check;
+ALTER TABLE tasks ADD CONSTRAINT tasks_status_check
+    CHECK (status IN ('pending', 'blocked', 'queued', 'processing', 'retrying', 'completed', 'failed', 'cancelled', 'skipped'));
+
+ALTER TABLE schedules DROP COLUMN IF EXISTS timeout_seconds;
+ALTER TABLE tasks DROP COLUMN IF EXISTS timeout_seconds;
@@ -0,0 +1,13 @@
+-- How long one attempt of a task may run, in seconds; 0 means no limit.
+-- Tasks get their type's default when created without one.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS timeout_seconds INT NOT NULL DEFAULT 0;
+ALTER TABLE schedules ADD COLUMN IF NOT EXISTS timeout_seconds INT NOT NULL DEFAULT 0;
+
+-- Tasks that run past their timeout end in 'timed_out' without a retry
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_status_check;
+ALTER TABLE tasks ADD CONSTRAINT tasks_status_check
+    CHECK (status IN ('pending', 'blocked', 'queued', 'processing', 'retrying', 'completed', 'failed', 'cancelled', 'skipped', 'timed_out'));
+
+ALTER TABLE task_attempts DROP CONSTRAINT IF EXISTS task_attempts_status_check;
+ALTER TABLE task_attempts ADD CONSTRAINT task_attempts_status_check
+    CHECK (status IN ('processing', 'completed', 'failed', 'timed_out'));
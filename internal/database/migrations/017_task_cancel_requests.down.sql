@@ -0,0 +1,6 @@
+UPDATE task_attempts SET status='failed' WHERE status='cancelled';
+ALTER TABLE task_attempts DROP CONSTRAINT IF EXISTS task_attempts_status_check;
+ALTER TABLE task_attempts ADD CONSTRAINT task_attempts_status_check
+    CHECK (status IN ('processing', 'completed', 'failed', 'timed_out'));
+
+ALTER TABLE tasks DROP COLUMN IF EXISTS cancel_requested;
@@ -0,0 +1,7 @@
+-- Set when the owner cancels a processing task. The task stays processing
+-- until its worker stops and ends it cancelled.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS cancel_requested BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE task_attempts DROP CONSTRAINT IF EXISTS task_attempts_status_check;
+ALTER TABLE task_attempts ADD CONSTRAINT task_attempts_status_check
+    CHECK (status IN ('processing', 'completed', 'failed', 'timed_out', 'cancelled'));
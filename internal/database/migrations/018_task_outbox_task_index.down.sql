@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_task_outbox_unpublished_task;
@@ -0,0 +1,4 @@
+-- Serves the check for a task whose message arrived before its outbox row
+-- was marked published.
+CREATE INDEX IF NOT EXISTS idx_task_outbox_unpublished_task
+    ON task_outbox(task_id) WHERE published_at IS NULL;
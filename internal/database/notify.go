@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MaxNotifyPayload is the largest payload Postgres accepts in a notification.
+const MaxNotifyPayload = 7999
+
+// TaskStatusChannel is the notification channel written by the
+// notify_task_status trigger on every task status change.
+const TaskStatusChannel = "task_status"
+
+// TaskStatusEvent is the payload of a task_status notification. EventID is
+// the task_updated event recorded for the change.
+type TaskStatusEvent struct {
+	EventID        int64  `json:"event_id,omitempty"`
+	TaskID         int64  `json:"task_id"`
+	UserID         int64  `json:"user_id"`
+	WorkflowID     int64  `json:"workflow_id,omitempty"`
+	Type           string `json:"type"`
+	Status         string `json:"status"`
+	PreviousStatus string `json:"previous_status"`
+	Attempt        int    `json:"attempt"`
+}
+
+// Notify sends payload to the listeners of channel.
+func Notify(ctx context.Context, db *pgxpool.Pool, channel, payload string) error {
+	_, err := db.Exec(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
+
+// Listen listens on channel on a dedicated connection and calls handle with
+// each notification's payload, until ctx is cancelled or the connection
+// fails. Notifications sent while nobody is listening are lost.
+func Listen(ctx context.Context, db *pgxpool.Pool, channel string, handle func(payload string)) error {
+	pooled, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// A listening connection must not go back to the pool.
+	conn := pooled.Hijack()
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handle(n.Payload)
+	}
+}
+
+// ListenTaskStatus calls handle with each task status change, as Listen.
+func ListenTaskStatus(ctx context.Context, db *pgxpool.Pool, handle func(*TaskStatusEvent)) error {
+	return Listen(ctx, db, TaskStatusChannel, func(payload string) {
+		var e TaskStatusEvent
+		if err := json.Unmarshal([]byte(payload), &e); err == nil {
+			handle(&e)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"taskqueue/internal/models"
+)
+
+// OutboxEntry is an unpublished outbox row joined with its task.
+type OutboxEntry struct {
+	ID       int64
+	TaskID   int64
+	UserID   int64
+	Type     string
+	Priority string
+	Status   string
+	Payload  json.RawMessage
+	Attempts int
+
+	// TimeoutSeconds is the task's timeout, zero if it has none.
+	TimeoutSeconds int
+}
+
+// PublishFunc publishes an outbox entry to the queue broker and returns the
+// broker message ID. tx is the transaction holding the outbox row lock, so
+// brokers backed by the same database can enqueue atomically with it.
+type PublishFunc func(ctx context.Context, tx pgx.Tx, e *OutboxEntry) (string, error)
+
+// CreateTaskWithOutbox inserts a pending task and its outbox row in one
+// transaction, so a task is never stored without being scheduled for publishing.
+func CreateTaskWithOutbox(ctx context.Context, db *pgxpool.Pool, t *models.Task) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := insertTask(ctx, tx, t); err != nil {
+			return err
+		}
+		return insertOutbox(ctx, tx, t.ID, 0)
+	})
+}
+
+// insertOutbox schedules a task for publishing after delaySeconds.
+func insertOutbox(ctx context.Context, q querier, taskID int64, delaySeconds float64) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO task_outbox (task_id, available_at)
+		VALUES ($1, CURRENT_TIMESTAMP + make_interval(secs => $2))`,
+		taskID, delaySeconds)
+	return err
+}
+
+// PublishNextOutbox locks the oldest due outbox row, publishes it with
+// publish and, in the same transaction, marks the row published and the task
+// queued with the returned message ID. Rows whose task is no longer pending
+// or retrying (for example cancelled) are marked published without calling
+// publish. Brokers outside the database can deliver the message before this
+// transaction commits; UpdateTaskProgress reports that as ErrNotQueuedYet.
+// A failed publish is recorded on the row and retried after a backoff.
+// It returns false when no row is due.
+func PublishNextOutbox(ctx context.Context, db *pgxpool.Pool, publish PublishFunc) (bool, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var e OutboxEntry
+	err = tx.QueryRow(ctx, `
+		SELECT o.id, o.task_id, t.user_id, t.type, t.priority, t.status,
+		       t.payload, o.attempts, t.timeout_seconds
+		FROM task_outbox o JOIN tasks t ON t.id = o.task_id
+		WHERE o.published_at IS NULL AND o.available_at <= CURRENT_TIMESTAMP
+		ORDER BY o.available_at, o.id
+		LIMIT 1
+		FOR UPDATE OF o SKIP LOCKED`).Scan(
+		&e.ID, &e.TaskID, &e.UserID, &e.Type, &e.Priority, &e.Status,
+		&e.Payload, &e.Attempts, &e.TimeoutSeconds,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if e.Status != "pending" && e.Status != "retrying" {
+		if _, err := tx.Exec(ctx, `
+			UPDATE task_outbox SET published_at=CURRENT_TIMESTAMP WHERE id=$1`, e.ID); err != nil {
+			return true, err
+		}
+		return true, tx.Commit(ctx)
+	}
+
+	msgID, pubErr := publish(ctx, tx, &e)
+	if pubErr != nil {
+		// Back off quadratically, capped at five minutes.
+		if _, err := tx.Exec(ctx, `
+			UPDATE task_outbox SET attempts=attempts+1, last_error=$1,
+			       available_at=CURRENT_TIMESTAMP + make_interval(secs => LEAST(POWER(attempts+1, 2), 300))
+			WHERE id=$2`, pubErr.Error(), e.ID); err != nil {
+			return true, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return true, err
+		}
+		return true, pubErr
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE task_outbox SET published_at=CURRENT_TIMESTAMP, message_id=$1,
+		       attempts=attempts+1, last_error=NULL
+		WHERE id=$2`, msgID, e.ID); err != nil {
+		return true, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE tasks SET status='queued', message_id=$1
+		WHERE id=$2 AND status IN ('pending', 'retrying')`, msgID, e.TaskID); err != nil {
+		return true, err
+	}
+	return true, tx.Commit(ctx)
+}
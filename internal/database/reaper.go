@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"taskqueue/internal/models"
+)
+
+// Reasons a processing task is reaped.
+const (
+	// ReapHeartbeatExpired means the task's worker stopped sending
+	// heartbeats, most likely because it crashed.
+	ReapHeartbeatExpired = "heartbeat_expired"
+	// ReapTimeout means the task ran longer than its timeout.
+	ReapTimeout = "timeout"
+)
+
+// ErrTaskNotStuck is returned by ReapTask when the task has moved on since
+// it was found stuck.
+var ErrTaskNotStuck = errors.New("task is no longer stuck")
+
+// StuckTask is a processing task the reaper should take back, and why.
+type StuckTask struct {
+	models.Task
+	Reason string
+}
+
+// ListStuckTasks returns up to limit processing tasks whose last heartbeat,
+// or start if they have none, is older than heartbeatTimeout, or which have
+// run longer than their timeout plus heartbeatTimeout. The grace leaves a
+// worker time to end a task that timed out itself. A task that is both is
+// reported as timed out.
+func ListStuckTasks(ctx context.Context, db *pgxpool.Pool, heartbeatTimeout time.Duration, limit int) ([]StuckTask, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+taskColumns+`,
+		       CASE WHEN timeout_seconds > 0
+		                 AND started_at < CURRENT_TIMESTAMP - make_interval(secs => timeout_seconds + $1::float8)
+		            THEN $2 ELSE $3 END
+		FROM tasks
+		WHERE status = 'processing'
+		AND (COALESCE(heartbeat_at, started_at) < CURRENT_TIMESTAMP - make_interval(secs => $1::float8)
+		     OR (timeout_seconds > 0
+		         AND started_at < CURRENT_TIMESTAMP - make_interval(secs => timeout_seconds + $1::float8)))
+		ORDER BY id
+		LIMIT $4`,
+		heartbeatTimeout.Seconds(), ReapTimeout, ReapHeartbeatExpired, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stuck := []StuckTask{}
+	for rows.Next() {
+		var st StuckTask
+		t, err := scanTask(reasonRow{rows, &st.Reason})
+		if err != nil {
+			return nil, err
+		}
+		st.Task = *t
+		stuck = append(stuck, st)
+	}
+	return stuck, rows.Err()
+}
+
+// reasonRow scans the extra reason column that follows taskColumns.
+type reasonRow struct {
+	pgx.Row
+	reason *string
+}
+
+func (r reasonRow) Scan(dest ...any) error {
+	return r.Row.Scan(append(dest, r.reason)...)
+}
+
+// ReapTask takes a stuck task back from its worker and records the reap with
+// its reason. A task whose heartbeat expired has the attempt failed with
+// errorMsg and is retried or failed by its retry policy, as by FailTask; one
+// that ran past its timeout ends timed_out, as by TimeoutTask. It returns the
+// task's new status, or ErrTaskNotStuck if the attempt has finished in the
+// meantime.
+func ReapTask(ctx context.Context, db *pgxpool.Pool, st *StuckTask, errorMsg string) (string, error) {
+	status := ""
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		t, err := scanTask(tx.QueryRow(ctx, `
+			SELECT `+taskColumns+` FROM tasks
+			WHERE id=$1 AND attempt=$2 AND status='processing' FOR UPDATE`,
+			st.ID, st.Attempt))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrTaskNotStuck
+		}
+		if err != nil {
+			return err
+		}
+
+		if st.Reason == ReapTimeout {
+			status = "timed_out"
+			err = timeoutAttempt(ctx, tx, t, errorMsg)
+		} else {
+			status, err = failAttempt(ctx, tx, t, errorMsg)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO task_reaps (task_id, attempt, worker_id, reason, status)
+			VALUES ($1, $2, NULLIF($3, ''), $4, $5)`,
+			t.ID, t.Attempt, t.WorkerID, st.Reason, status)
+		return err
+	})
+	return status, err
+}
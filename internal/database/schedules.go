@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"taskqueue/internal/models"
+)
+
+// ErrScheduleNotFound is returned when a schedule does not exist for the user.
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// scheduleColumns is the column list read by scanSchedule.
+const scheduleColumns = `id, user_id, name, type, priority, payload,
+        max_attempts, backoff, backoff_seconds, timeout_seconds, COALESCE(cron_expr, ''),
+        run_at, next_run_at, last_run_at, COALESCE(last_task_id, 0), enabled,
+        created_at, updated_at`
+
+func scanSchedule(row pgx.Row) (*models.Schedule, error) {
+	var s models.Schedule
+	var runAt, nextRunAt, lastRunAt sql.NullTime
+	if err := row.Scan(&s.ID, &s.UserID, &s.Name, &s.Type, &s.Priority, &s.Payload,
+		&s.MaxAttempts, &s.Backoff, &s.BackoffSeconds, &s.TimeoutSeconds, &s.Cron,
+		&runAt, &nextRunAt, &lastRunAt, &s.LastTaskID, &s.Enabled,
+		&s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	s.RunAt, s.NextRunAt, s.LastRunAt = runAt.Time, nextRunAt.Time, lastRunAt.Time
+	return &s, nil
+}
+
+// nullTime maps the zero time to NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// CreateSchedule inserts a schedule. Exactly one of Cron and RunAt must be
+// set, and NextRunAt must hold the first run time.
+func CreateSchedule(ctx context.Context, db *pgxpool.Pool, s *models.Schedule) error {
+	return db.QueryRow(ctx, `
+		INSERT INTO schedules (user_id, name, type, priority, payload,
+		       max_attempts, backoff, backoff_seconds, timeout_seconds, cron_expr, run_at, next_run_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,NULLIF($10, ''),$11,$12)
+		RETURNING id, enabled, created_at, updated_at`,
+		s.UserID, s.Name, s.Type, s.Priority, s.Payload,
+		s.MaxAttempts, s.Backoff, s.BackoffSeconds, s.TimeoutSeconds, s.Cron,
+		nullTime(s.RunAt), nullTime(s.NextRunAt),
+	).Scan(&s.ID, &s.Enabled, &s.CreatedAt, &s.UpdatedAt)
+}
+
+// GetSchedule returns a single schedule by ID
+func GetSchedule(ctx context.Context, db *pgxpool.Pool, scheduleID, userID int64) (*models.Schedule, error) {
+	s, err := scanSchedule(db.QueryRow(ctx, `
+		SELECT `+scheduleColumns+` FROM schedules WHERE id=$1 AND user_id=$2`,
+		scheduleID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrScheduleNotFound
+	}
+	return s, err
+}
+
+// ListSchedules returns a user's schedules, newest first
+func ListSchedules(ctx context.Context, db *pgxpool.Pool, userID int64, limit, offset int) ([]models.Schedule, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+scheduleColumns+` FROM schedules WHERE user_id=$1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3`, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return collectSchedules(rows)
+}
+
+// DeleteSchedule removes a schedule. Tasks it already created are kept.
+func DeleteSchedule(ctx context.Context, db *pgxpool.Pool, scheduleID, userID int64) error {
+	result, err := db.Exec(ctx, `
+		DELETE FROM schedules WHERE id=$1 AND user_id=$2`, scheduleID, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrScheduleNotFound
+	}
+	return nil
+}
+
+// ListDueSchedules returns enabled schedules whose next run is at or before
+// now, earliest first.
+func ListDueSchedules(ctx context.Context, db *pgxpool.Pool, now time.Time, limit int) ([]models.Schedule, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+scheduleColumns+` FROM schedules
+		WHERE enabled AND next_run_at <= $1
+		ORDER BY next_run_at, id
+		LIMIT $2`, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectSchedules(rows)
+}
+
+// MarkScheduleRun records that a schedule created taskID at ranAt and sets
+// its next run. A zero next disables the schedule.
+func MarkScheduleRun(ctx context.Context, db *pgxpool.Pool, scheduleID, taskID int64, ranAt, next time.Time) error {
+	_, err := db.Exec(ctx, `
+		UPDATE schedules SET last_run_at=$1, last_task_id=NULLIF($2, 0), next_run_at=$3,
+		       enabled = enabled AND $3::timestamp IS NOT NULL
+		WHERE id=$4`, nullTime(ranAt), taskID, nullTime(next), scheduleID)
+	return err
+}
+
+func collectSchedules(rows pgx.Rows) ([]models.Schedule, error) {
+	defer rows.Close()
+
+	schedules := []models.Schedule{}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *s)
+	}
+	return schedules, rows.Err()
+}
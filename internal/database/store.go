@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"taskqueue/internal/models"
+)
+
+// TaskStore is the task storage used by the HTTP handlers. Lookups of a
+// missing task return pgx.ErrNoRows.
+type TaskStore interface {
+	// CreateTask stores a pending task and schedules it for publishing.
+	CreateTask(ctx context.Context, t *models.Task) error
+	// CreateTaskWithIdempotencyKey is CreateTask that also records k, or
+	// returns ErrIdempotencyKeyExists if the user already holds the key.
+	CreateTaskWithIdempotencyKey(ctx context.Context, t *models.Task, k *IdempotencyKey) error
+	GetIdempotencyKey(ctx context.Context, userID int64, key string) (*IdempotencyKey, error)
+
+	GetTask(ctx context.Context, taskID, userID int64) (*models.Task, error)
+	ListTasks(ctx context.Context, userID int64, filter *TaskFilter) (*TaskPage, error)
+	ListTaskAttempts(ctx context.Context, taskID int64) ([]models.TaskAttempt, error)
+	CancelTask(ctx context.Context, taskID, userID int64) (*models.Task, error)
+	GetTaskStats(ctx context.Context, userID int64) (*TaskStats, error)
+}
+
+// UserStore is the user storage used by the HTTP handlers. Lookups of a
+// missing user return pgx.ErrNoRows.
+type UserStore interface {
+	// UpsertUser creates or updates the user with googleID and returns its ID.
+	UpsertUser(ctx context.Context, googleID, email, name, picture string) (int64, error)
+	GetUser(ctx context.Context, userID int64) (*models.User, error)
+}
+
+// WorkerStore is the task storage used by workers, which identify a task by
+// the message that carries it. Calls for a message that no task in the
+// expected status carries return ErrStaleMessage.
+type WorkerStore interface {
+	RegisterWorker(ctx context.Context, w *models.Worker) error
+	WorkerHeartbeat(ctx context.Context, workerID string, currentTaskID int64) error
+	InsertDeadLetter(ctx context.Context, dl *models.DeadLetter) error
+
+	UpdateTaskProgress(ctx context.Context, messageID, workerID string, taskID int64) error
+	TaskHeartbeat(ctx context.Context, messageID string) (*models.Task, error)
+	CompleteTask(ctx context.Context, messageID string, result []byte) error
+	FailTask(ctx context.Context, messageID, errorMsg string) (string, error)
+	TimeoutTask(ctx context.Context, messageID, errorMsg string) error
+	CancelRunningTask(ctx context.Context, messageID string) error
+}
+
+// PgStore implements TaskStore, UserStore and WorkerStore on Postgres.
+type PgStore struct {
+	DB *pgxpool.Pool
+}
+
+var (
+	_ TaskStore   = (*PgStore)(nil)
+	_ UserStore   = (*PgStore)(nil)
+	_ WorkerStore = (*PgStore)(nil)
+)
+
+// NewPgStore creates a store backed by db.
+func NewPgStore(db *pgxpool.Pool) *PgStore {
+	return &PgStore{DB: db}
+}
+
+func (s *PgStore) CreateTask(ctx context.Context, t *models.Task) error {
+	return CreateTaskWithOutbox(ctx, s.DB, t)
+}
+
+func (s *PgStore) CreateTaskWithIdempotencyKey(ctx context.Context, t *models.Task, k *IdempotencyKey) error {
+	return CreateTaskWithIdempotencyKey(ctx, s.DB, t, k)
+}
+
+func (s *PgStore) GetIdempotencyKey(ctx context.Context, userID int64, key string) (*IdempotencyKey, error) {
+	return GetIdempotencyKey(ctx, s.DB, userID, key)
+}
+
+func (s *PgStore) GetTask(ctx context.Context, taskID, userID int64) (*models.Task, error) {
+	return GetTask(ctx, s.DB, taskID, userID)
+}
+
+func (s *PgStore) ListTasks(ctx context.Context, userID int64, filter *TaskFilter) (*TaskPage, error) {
+	return ListTasks(ctx, s.DB, userID, filter)
+}
+
+func (s *PgStore) ListTaskAttempts(ctx context.Context, taskID int64) ([]models.TaskAttempt, error) {
+	return ListTaskAttempts(ctx, s.DB, taskID)
+}
+
+func (s *PgStore) CancelTask(ctx context.Context, taskID, userID int64) (*models.Task, error) {
+	return CancelTask(ctx, s.DB, taskID, userID)
+}
+
+func (s *PgStore) GetTaskStats(ctx context.Context, userID int64) (*TaskStats, error) {
+	return GetTaskStats(ctx, s.DB, userID)
+}
+
+func (s *PgStore) UpsertUser(ctx context.Context, googleID, email, name, picture string) (int64, error) {
+	return CreateUser(ctx, s.DB, googleID, email, name, picture)
+}
+
+func (s *PgStore) GetUser(ctx context.Context, userID int64) (*models.User, error) {
+	return GetUser(ctx, s.DB, userID)
+}
+
+func (s *PgStore) RegisterWorker(ctx context.Context, w *models.Worker) error {
+	return RegisterWorker(ctx, s.DB, w)
+}
+
+func (s *PgStore) WorkerHeartbeat(ctx context.Context, workerID string, currentTaskID int64) error {
+	return WorkerHeartbeat(ctx, s.DB, workerID, currentTaskID)
+}
+
+func (s *PgStore) InsertDeadLetter(ctx context.Context, dl *models.DeadLetter) error {
+	return InsertDeadLetter(ctx, s.DB, dl)
+}
+
+func (s *PgStore) UpdateTaskProgress(ctx context.Context, messageID, workerID string, taskID int64) error {
+	return UpdateTaskProgress(ctx, s.DB, messageID, workerID, taskID)
+}
+
+func (s *PgStore) TaskHeartbeat(ctx context.Context, messageID string) (*models.Task, error) {
+	return TaskHeartbeat(ctx, s.DB, messageID)
+}
+
+func (s *PgStore) CompleteTask(ctx context.Context, messageID string, result []byte) error {
+	return CompleteTask(ctx, s.DB, messageID, result)
+}
+
+func (s *PgStore) FailTask(ctx context.Context, messageID, errorMsg string) (string, error) {
+	return FailTask(ctx, s.DB, messageID, errorMsg)
+}
+
+func (s *PgStore) TimeoutTask(ctx context.Context, messageID, errorMsg string) error {
+	return TimeoutTask(ctx, s.DB, messageID, errorMsg)
+}
+
+func (s *PgStore) CancelRunningTask(ctx context.Context, messageID string) error {
+	return CancelRunningTask(ctx, s.DB, messageID)
+}
@@ -3,27 +3,79 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"taskqueue/internal/models"
 )
 
+// ErrStaleMessage is returned when a queue message no longer matches a task
+// waiting to be processed, or being processed.
+var ErrStaleMessage = errors.New("no queued or processing task for message")
+
+// ErrNotQueuedYet is returned when a message arrives before the outbox
+// transaction that sent it has committed, which brokers outside the database
+// allow. The message should be redelivered shortly rather than dropped.
+var ErrNotQueuedYet = errors.New("task not queued yet for message")
+
 // CreateTask inserts a new task row and returns the filled task.
 func CreateTask(ctx context.Context, db *pgxpool.Pool, t *models.Task) error {
-	query := `INSERT INTO tasks (user_id, name, type, priority, status, payload)
-              VALUES ($1,$2,$3,$4,$5,$6)
+	return insertTask(ctx, db, t)
+}
+
+func insertTask(ctx context.Context, q querier, t *models.Task) error {
+	query := `INSERT INTO tasks (user_id, name, type, priority, status, payload,
+                  max_attempts, backoff, backoff_seconds, timeout_seconds, workflow_id)
+              VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,NULLIF($11, 0))
               RETURNING id, created_at, updated_at`
-	return db.QueryRow(ctx, query,
+	return q.QueryRow(ctx, query,
 		t.UserID, t.Name, t.Type, t.Priority, t.Status, t.Payload,
+		t.MaxAttempts, t.Backoff, t.BackoffSeconds, t.TimeoutSeconds, t.WorkflowID,
 	).Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt)
 }
 
-// ListTasks returns tasks for a user with pagination and filtering
-func ListTasks(ctx context.Context, db *pgxpool.Pool, userID int64, filter *TaskFilter) ([]models.Task, error) {
-	query := `SELECT id, user_id, name, type, priority, status, payload,
-        result, error_message, message_id, worker_id, started_at, completed_at,
-        created_at, updated_at FROM tasks WHERE user_id=$1`
+// taskColumns is the column list read by scanTask. Nullable text columns are
+// coalesced so they scan into plain strings.
+const taskColumns = `id, user_id, name, type, priority, status, payload,
+        result, COALESCE(error_message, ''), COALESCE(message_id, ''),
+        COALESCE(worker_id, ''), started_at, completed_at, created_at, updated_at,
+        attempt, max_attempts, backoff, backoff_seconds, timeout_seconds,
+        cancel_requested, COALESCE(workflow_id, 0)`
+
+// scanTask scans a row selected with taskColumns.
+func scanTask(row pgx.Row) (*models.Task, error) {
+	var t models.Task
+	var startedAt, completedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Type, &t.Priority, &t.Status,
+		&t.Payload, &t.Result, &t.Error, &t.MessageID, &t.WorkerID,
+		&startedAt, &completedAt, &t.CreatedAt, &t.UpdatedAt,
+		&t.Attempt, &t.MaxAttempts, &t.Backoff, &t.BackoffSeconds, &t.TimeoutSeconds,
+		&t.CancelRequested, &t.WorkflowID); err != nil {
+		return nil, err
+	}
+	if startedAt.Valid {
+		t.StartedAt = startedAt.Time
+	}
+	if completedAt.Valid {
+		t.CompletedAt = completedAt.Time
+	}
+	return &t, nil
+}
+
+// ListTasks returns a page of a user's tasks matching filter, in the order
+// it asks for. Pages are keyed on the sort value and ID, starting after
+// filter.Cursor, so rows are neither skipped nor repeated when tasks are
+// added between requests.
+func ListTasks(ctx context.Context, db *pgxpool.Pool, userID int64, filter *TaskFilter) (*TaskPage, error) {
+	if err := filter.check(); err != nil {
+		return nil, err
+	}
+	
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE user_id=$1`
 	
 	args := []interface{}{userID}
 	argIndex := 2
@@ -46,17 +98,66 @@ func ListTasks(ctx context.Context, db *pgxpool.Pool, userID int64, filter *Task
 		argIndex++
 	}
 	
-	query += " ORDER BY created_at DESC"
+	ranges := []struct {
+		cond  string
+		value time.Time
+	}{
+		{"created_at >= $%d", filter.CreatedAfter},
+		{"created_at < $%d", filter.CreatedBefore},
+		{"completed_at >= $%d", filter.CompletedAfter},
+		{"completed_at < $%d", filter.CompletedBefore},
+	}
+	for _, r := range ranges {
+		if !r.value.IsZero() {
+			query += " AND " + fmt.Sprintf(r.cond, argIndex)
+			args = append(args, r.value)
+			argIndex++
+		}
+	}
 	
-	if filter.Limit > 0 {
-		query += fmt.Sprintf(" LIMIT $%d", argIndex)
-		args = append(args, filter.Limit)
+	if filter.Search != "" {
+		// Must match the expression of idx_tasks_search.
+		query += fmt.Sprintf(" AND to_tsvector('english', name || ' ' || COALESCE(error_message, '')) @@ websearch_to_tsquery('english', $%d)", argIndex)
+		args = append(args, filter.Search)
+		argIndex++
+	}
+	
+	if len(filter.Payload) > 0 {
+		query += fmt.Sprintf(" AND payload @> $%d::jsonb", argIndex)
+		args = append(args, string(filter.Payload))
 		argIndex++
 	}
 	
-	if filter.Offset > 0 {
-		query += fmt.Sprintf(" OFFSET $%d", argIndex)
-		args = append(args, filter.Offset)
+	if len(filter.Result) > 0 {
+		query += fmt.Sprintf(" AND result @> $%d::jsonb", argIndex)
+		args = append(args, string(filter.Result))
+		argIndex++
+	}
+	
+	// Rows are read in list order, or in reverse when paging back.
+	expr := taskSortExprs[filter.sortField()]
+	cursor := filter.Cursor
+	backwards := cursor != nil && cursor.Prev
+	asc := filter.Asc != backwards
+	dir, op := "DESC", "<"
+	if asc {
+		dir, op = "ASC", ">"
+	}
+	if cursor != nil {
+		keyType := "timestamp"
+		if cursor.Sort == SortName {
+			keyType = "text"
+		}
+		query += fmt.Sprintf(" AND (%s, id) %s ($%d::%s, $%d::bigint)", expr, op, argIndex, keyType, argIndex+1)
+		args = append(args, cursor.key(), cursor.ID)
+		argIndex += 2
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s", expr, dir, dir)
+	
+	if filter.Limit > 0 {
+		// One extra row tells whether another page follows.
+		query += fmt.Sprintf(" LIMIT $%d", argIndex)
+		args = append(args, filter.Limit+1)
 	}
 	
 	rows, err := db.Query(ctx, query, args...)
@@ -67,51 +168,54 @@ func ListTasks(ctx context.Context, db *pgxpool.Pool, userID int64, filter *Task
 	
 	tasks := []models.Task{}
 	for rows.Next() {
-		var t models.Task
-		var startedAt, completedAt sql.NullTime
-		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Type, &t.Priority, &t.Status,
-			&t.Payload, &t.Result, &t.Error, &t.MessageID, &t.WorkerID,
-			&startedAt, &completedAt, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		t, err := scanTask(rows)
+		if err != nil {
 			return nil, err
 		}
-		if startedAt.Valid {
-			t.StartedAt = startedAt.Time
-		}
-		if completedAt.Valid {
-			t.CompletedAt = completedAt.Time
-		}
-		tasks = append(tasks, t)
+		tasks = append(tasks, *t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	return tasks, rows.Err()
+	
+	if backwards {
+		slices.Reverse(tasks)
+	}
+	return newTaskPage(tasks, filter), nil
 }
 
 // GetTask returns a single task by ID
 func GetTask(ctx context.Context, db *pgxpool.Pool, taskID, userID int64) (*models.Task, error) {
-	var t models.Task
-	var startedAt, completedAt sql.NullTime
-	
-	err := db.QueryRow(ctx, `
-		SELECT id, user_id, name, type, priority, status, payload,
-		result, error_message, message_id, worker_id, started_at, completed_at,
-		created_at, updated_at FROM tasks WHERE id=$1 AND user_id=$2`,
-		taskID, userID).Scan(
-		&t.ID, &t.UserID, &t.Name, &t.Type, &t.Priority, &t.Status,
-		&t.Payload, &t.Result, &t.Error, &t.MessageID, &t.WorkerID,
-		&startedAt, &completedAt, &t.CreatedAt, &t.UpdatedAt,
-	)
-	
+	return scanTask(db.QueryRow(ctx, `
+		SELECT `+taskColumns+` FROM tasks WHERE id=$1 AND user_id=$2`,
+		taskID, userID))
+}
+
+// ListTaskAttempts returns the attempt history of a task, oldest first
+func ListTaskAttempts(ctx context.Context, db *pgxpool.Pool, taskID int64) ([]models.TaskAttempt, error) {
+	rows, err := db.Query(ctx, `
+		SELECT id, task_id, attempt, status, COALESCE(worker_id, ''),
+		COALESCE(message_id, ''), COALESCE(error_message, ''), started_at, finished_at
+		FROM task_attempts WHERE task_id=$1 ORDER BY attempt`, taskID)
 	if err != nil {
 		return nil, err
 	}
-	
-	if startedAt.Valid {
-		t.StartedAt = startedAt.Time
-	}
-	if completedAt.Valid {
-		t.CompletedAt = completedAt.Time
+	defer rows.Close()
+
+	attempts := []models.TaskAttempt{}
+	for rows.Next() {
+		var a models.TaskAttempt
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.TaskID, &a.Attempt, &a.Status, &a.WorkerID,
+			&a.MessageID, &a.Error, &a.StartedAt, &finishedAt); err != nil {
+			return nil, err
+		}
+		if finishedAt.Valid {
+			a.FinishedAt = finishedAt.Time
+		}
+		attempts = append(attempts, a)
 	}
-	
-	return &t, nil
+	return attempts, rows.Err()
 }
 
 // UpdateTaskStatus updates a task's status and related fields
@@ -122,46 +226,248 @@ func UpdateTaskStatus(ctx context.Context, db *pgxpool.Pool, taskID int64, statu
 	return err
 }
 
-// UpdateTaskProgress updates a task when worker starts processing
-func UpdateTaskProgress(ctx context.Context, db *pgxpool.Pool, messageID, workerID string) error {
-	_, err := db.Exec(ctx, `
-		UPDATE tasks SET status='processing', worker_id=$1, started_at=CURRENT_TIMESTAMP
-		WHERE message_id=$2`, workerID, messageID)
-	return err
+// UpdateTaskProgress updates a task when worker starts processing and opens
+// a new attempt. It returns ErrStaleMessage if no queued task carries
+// messageID, which happens for duplicate deliveries and for tasks cancelled
+// while queued, and ErrNotQueuedYet if taskID, the task named in the
+// message, still has an unpublished outbox row.
+func UpdateTaskProgress(ctx context.Context, db *pgxpool.Pool, messageID, workerID string, taskID int64) error {
+	result, err := db.Exec(ctx, `
+		WITH t AS (
+			UPDATE tasks SET status='processing', worker_id=$1, started_at=CURRENT_TIMESTAMP,
+			       heartbeat_at=CURRENT_TIMESTAMP, attempt=attempt+1
+			WHERE message_id=$2 AND status='queued'
+			RETURNING id, attempt
+		)
+		INSERT INTO task_attempts (task_id, attempt, worker_id, message_id)
+		SELECT id, attempt, $1, $2 FROM t`, workerID, messageID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() > 0 {
+		return nil
+	}
+
+	var unpublished bool
+	if err := db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM task_outbox WHERE task_id=$1 AND published_at IS NULL)`,
+		taskID).Scan(&unpublished); err != nil {
+		return err
+	}
+	if unpublished {
+		return ErrNotQueuedYet
+	}
+	return ErrStaleMessage
+}
+
+// TaskHeartbeat records that the task carrying messageID is still being
+// processed and returns it. It returns ErrStaleMessage if the task is no
+// longer processing.
+func TaskHeartbeat(ctx context.Context, db *pgxpool.Pool, messageID string) (*models.Task, error) {
+	t, err := scanTask(db.QueryRow(ctx, `
+		UPDATE tasks SET heartbeat_at=CURRENT_TIMESTAMP
+		WHERE message_id=$1 AND status='processing'
+		RETURNING `+taskColumns, messageID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrStaleMessage
+	}
+	return t, err
 }
 
-// CompleteTask marks a task as completed with result
+// CompleteTask marks a processing task as completed with result and releases
+// any dependent tasks whose parents have now all completed. It returns
+// ErrStaleMessage if no processing task carries messageID.
 func CompleteTask(ctx context.Context, db *pgxpool.Pool, messageID string, result []byte) error {
-	_, err := db.Exec(ctx, `
-		UPDATE tasks SET status='completed', result=$1, completed_at=CURRENT_TIMESTAMP
-		WHERE message_id=$2`, result, messageID)
-	return err
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		var taskID int64
+		err := tx.QueryRow(ctx, `
+			WITH t AS (
+				UPDATE tasks SET status='completed', result=$1, completed_at=CURRENT_TIMESTAMP
+				WHERE message_id=$2 AND status='processing'
+				RETURNING id, attempt
+			), a AS (
+				UPDATE task_attempts a SET status='completed', finished_at=CURRENT_TIMESTAMP
+				FROM t WHERE a.task_id=t.id AND a.attempt=t.attempt AND a.status='processing'
+			)
+			SELECT id FROM t`, result, messageID).Scan(&taskID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrStaleMessage
+		}
+		if err != nil {
+			return err
+		}
+		return releaseDependents(ctx, tx, taskID)
+	})
 }
 
-// FailTask marks a task as failed with error message
-func FailTask(ctx context.Context, db *pgxpool.Pool, messageID string, errorMsg string) error {
-	_, err := db.Exec(ctx, `
+// FailTask records a failed attempt. If the task has attempts left it moves
+// to 'retrying' and is re-enqueued through the outbox after its backoff delay;
+// otherwise it is marked failed and dead-lettered, and its blocked dependents
+// are skipped. A task whose owner asked to cancel it is cancelled instead.
+// It returns the task's new status.
+func FailTask(ctx context.Context, db *pgxpool.Pool, messageID string, errorMsg string) (string, error) {
+	status := ""
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		t, err := scanTask(tx.QueryRow(ctx, `
+			SELECT `+taskColumns+` FROM tasks
+			WHERE message_id=$1 AND status='processing' FOR UPDATE`, messageID))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrStaleMessage
+		}
+		if err != nil {
+			return err
+		}
+		status, err = failAttempt(ctx, tx, t, errorMsg)
+		return err
+	})
+	return status, err
+}
+
+// failAttempt fails the current attempt of t, a processing task locked by
+// tx, as FailTask describes, and returns the task's new status.
+func failAttempt(ctx context.Context, tx pgx.Tx, t *models.Task, errorMsg string) (string, error) {
+	if t.CancelRequested {
+		return "cancelled", cancelAttempt(ctx, tx, t, errorMsg)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE task_attempts SET status='failed', error_message=$1, finished_at=CURRENT_TIMESTAMP
+		WHERE task_id=$2 AND attempt=$3 AND status='processing'`,
+		errorMsg, t.ID, t.Attempt); err != nil {
+		return "", err
+	}
+
+	if t.Attempt < t.MaxAttempts {
+		if _, err := tx.Exec(ctx, `
+			UPDATE tasks SET status='retrying', error_message=$1 WHERE id=$2`,
+			errorMsg, t.ID); err != nil {
+			return "", err
+		}
+		return "retrying", insertOutbox(ctx, tx, t.ID, t.RetryDelay().Seconds())
+	}
+
+	if _, err := tx.Exec(ctx, `
 		UPDATE tasks SET status='failed', error_message=$1, completed_at=CURRENT_TIMESTAMP
-		WHERE message_id=$2`, errorMsg, messageID)
-	return err
+		WHERE id=$2`, errorMsg, t.ID); err != nil {
+		return "", err
+	}
+	if err := skipDependents(ctx, tx, t.ID, fmt.Sprintf("dependency %d failed", t.ID)); err != nil {
+		return "", err
+	}
+	return "failed", insertDeadLetter(ctx, tx, &models.DeadLetter{
+		TaskID:    t.ID,
+		MessageID: t.MessageID,
+		Reason:    "exhausted",
+		Error:     errorMsg,
+		Attempts:  t.Attempt,
+	})
 }
 
-// CancelTask cancels a pending/queued task
-func CancelTask(ctx context.Context, db *pgxpool.Pool, taskID, userID int64) error {
-	result, err := db.Exec(ctx, `
-		UPDATE tasks SET status='cancelled', completed_at=CURRENT_TIMESTAMP
-		WHERE id=$1 AND user_id=$2 AND status IN ('pending', 'queued')`,
-		taskID, userID)
-	
+// TimeoutTask ends a processing task that ran past its timeout: the attempt
+// and the task are marked timed_out with errorMsg, whatever attempts remain,
+// and its blocked dependents are skipped. It returns ErrStaleMessage if no
+// processing task carries messageID.
+func TimeoutTask(ctx context.Context, db *pgxpool.Pool, messageID string, errorMsg string) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		t, err := scanTask(tx.QueryRow(ctx, `
+			SELECT `+taskColumns+` FROM tasks
+			WHERE message_id=$1 AND status='processing' FOR UPDATE`, messageID))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrStaleMessage
+		}
+		if err != nil {
+			return err
+		}
+		return timeoutAttempt(ctx, tx, t, errorMsg)
+	})
+}
+
+// timeoutAttempt ends t, a processing task locked by tx, as TimeoutTask
+// describes.
+func timeoutAttempt(ctx context.Context, tx pgx.Tx, t *models.Task, errorMsg string) error {
+	if _, err := tx.Exec(ctx, `
+		UPDATE task_attempts SET status='timed_out', error_message=$1, finished_at=CURRENT_TIMESTAMP
+		WHERE task_id=$2 AND attempt=$3 AND status='processing'`,
+		errorMsg, t.ID, t.Attempt); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE tasks SET status='timed_out', error_message=$1, completed_at=CURRENT_TIMESTAMP
+		WHERE id=$2`, errorMsg, t.ID); err != nil {
+		return err
+	}
+	return skipDependents(ctx, tx, t.ID, fmt.Sprintf("dependency %d timed out", t.ID))
+}
+
+// CancelTask cancels a pending, blocked, queued or retrying task and skips
+// its blocked dependents, returning the task in status cancelled. A
+// processing task is only marked cancel_requested and returned still
+// processing; its worker learns of the request from its heartbeats and ends
+// the task with CancelRunningTask.
+func CancelTask(ctx context.Context, db *pgxpool.Pool, taskID, userID int64) (*models.Task, error) {
+	var t *models.Task
+	err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		var err error
+		t, err = scanTask(tx.QueryRow(ctx, `
+			UPDATE tasks SET status='cancelled', completed_at=CURRENT_TIMESTAMP
+			WHERE id=$1 AND user_id=$2 AND status IN ('pending', 'blocked', 'queued', 'retrying')
+			RETURNING `+taskColumns, taskID, userID))
+		if err == nil {
+			return skipDependents(ctx, tx, taskID, fmt.Sprintf("dependency %d cancelled", taskID))
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+
+		t, err = scanTask(tx.QueryRow(ctx, `
+			UPDATE tasks SET cancel_requested=true
+			WHERE id=$1 AND user_id=$2 AND status='processing'
+			RETURNING `+taskColumns, taskID, userID))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("task not found or cannot be cancelled")
+		}
+		return err
+	})
 	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// CancelRunningTask ends a processing task that its worker stopped at its
+// owner's request: the attempt and the task are marked cancelled and its
+// blocked dependents are skipped. It returns ErrStaleMessage if no
+// processing task carries messageID.
+func CancelRunningTask(ctx context.Context, db *pgxpool.Pool, messageID string) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		t, err := scanTask(tx.QueryRow(ctx, `
+			SELECT `+taskColumns+` FROM tasks
+			WHERE message_id=$1 AND status='processing' FOR UPDATE`, messageID))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrStaleMessage
+		}
+		if err != nil {
+			return err
+		}
+		return cancelAttempt(ctx, tx, t, "cancelled by owner")
+	})
+}
+
+// cancelAttempt ends t, a processing task locked by tx, as CancelRunningTask
+// describes, recording errorMsg on the attempt.
+func cancelAttempt(ctx context.Context, tx pgx.Tx, t *models.Task, errorMsg string) error {
+	if _, err := tx.Exec(ctx, `
+		UPDATE task_attempts SET status='cancelled', error_message=$1, finished_at=CURRENT_TIMESTAMP
+		WHERE task_id=$2 AND attempt=$3 AND status='processing'`,
+		errorMsg, t.ID, t.Attempt); err != nil {
 		return err
 	}
-	
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("task not found or cannot be cancelled")
+	if _, err := tx.Exec(ctx, `
+		UPDATE tasks SET status='cancelled', completed_at=CURRENT_TIMESTAMP
+		WHERE id=$1`, t.ID); err != nil {
+		return err
 	}
-	
-	return nil
+	return skipDependents(ctx, tx, t.ID, fmt.Sprintf("dependency %d cancelled", t.ID))
 }
 
 // GetTaskStats returns task statistics for a user
@@ -172,14 +478,19 @@ func GetTaskStats(ctx context.Context, db *pgxpool.Pool, userID int64) (*TaskSta
 		SELECT 
 			COUNT(*) as total,
 			COUNT(*) FILTER (WHERE status = 'pending') as pending,
+			COUNT(*) FILTER (WHERE status = 'blocked') as blocked,
 			COUNT(*) FILTER (WHERE status = 'queued') as queued,
 			COUNT(*) FILTER (WHERE status = 'processing') as processing,
+			COUNT(*) FILTER (WHERE status = 'retrying') as retrying,
 			COUNT(*) FILTER (WHERE status = 'completed') as completed,
 			COUNT(*) FILTER (WHERE status = 'failed') as failed,
-			COUNT(*) FILTER (WHERE status = 'cancelled') as cancelled
+			COUNT(*) FILTER (WHERE status = 'cancelled') as cancelled,
+			COUNT(*) FILTER (WHERE status = 'skipped') as skipped,
+			COUNT(*) FILTER (WHERE status = 'timed_out') as timed_out
 		FROM tasks WHERE user_id=$1`, userID).Scan(
-		&stats.Total, &stats.Pending, &stats.Queued, &stats.Processing,
-		&stats.Completed, &stats.Failed, &stats.Cancelled,
+		&stats.Total, &stats.Pending, &stats.Blocked, &stats.Queued, &stats.Processing,
+		&stats.Retrying, &stats.Completed, &stats.Failed, &stats.Cancelled, &stats.Skipped,
+		&stats.TimedOut,
 	)
 	
 	return &stats, err
@@ -190,17 +501,42 @@ type TaskFilter struct {
 	Status   string
 	Type     string
 	Priority string
-	Limit    int
-	Offset   int
+	
+	// Time ranges include their start and exclude their end; zero times
+	// leave a range open.
+	CreatedAfter    time.Time
+	CreatedBefore   time.Time
+	CompletedAfter  time.Time
+	CompletedBefore time.Time
+	
+	// Search is a web-style text query matched against the name and error
+	// message.
+	Search string
+	
+	// Payload and Result match tasks whose JSON contains these documents.
+	Payload []byte
+	Result  []byte
+	
+	// Sort is one of the Sort fields, SortCreatedAt if empty. Tasks are
+	// listed in descending order unless Asc is set.
+	Sort string
+	Asc  bool
+	
+	Limit  int
+	Cursor *Cursor
 }
 
 // TaskStats contains task statistics
 type TaskStats struct {
 	Total      int `json:"total"`
 	Pending    int `json:"pending"`
+	Blocked    int `json:"blocked"`
 	Queued     int `json:"queued"`
 	Processing int `json:"processing"`
+	Retrying   int `json:"retrying"`
 	Completed  int `json:"completed"`
 	Failed     int `json:"failed"`
 	Cancelled  int `json:"cancelled"`
+	Skipped    int `json:"skipped"`
+	TimedOut   int `json:"timed_out"`
 }
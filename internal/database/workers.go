@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"taskqueue/internal/models"
+)
+
+// ErrWorkerNotFound is returned for a heartbeat from a worker that has not
+// registered. The worker should register again.
+var ErrWorkerNotFound = errors.New("worker not registered")
+
+// Worker health reported by ListWorkers.
+const (
+	WorkerHealthy = "healthy"
+	WorkerStale   = "stale"
+	WorkerDead    = "dead"
+)
+
+// RegisterWorker records a worker starting, replacing any earlier
+// registration with the same ID, and sets its StartedAt and LastHeartbeatAt.
+func RegisterWorker(ctx context.Context, db *pgxpool.Pool, w *models.Worker) error {
+	if w.TaskTypes == nil {
+		w.TaskTypes = []string{}
+	}
+	return db.QueryRow(ctx, `
+		INSERT INTO workers (id, language, host, task_types, version)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET language=EXCLUDED.language, host=EXCLUDED.host,
+		    task_types=EXCLUDED.task_types, version=EXCLUDED.version, current_task_id=NULL,
+		    started_at=CURRENT_TIMESTAMP, last_heartbeat_at=CURRENT_TIMESTAMP
+		RETURNING started_at, last_heartbeat_at`,
+		w.ID, w.Language, w.Host, w.TaskTypes, w.Version,
+	).Scan(&w.StartedAt, &w.LastHeartbeatAt)
+}
+
+// WorkerHeartbeat records that a worker is alive and running currentTaskID,
+// or idle if it is zero.
+func WorkerHeartbeat(ctx context.Context, db *pgxpool.Pool, workerID string, currentTaskID int64) error {
+	result, err := db.Exec(ctx, `
+		UPDATE workers SET last_heartbeat_at=CURRENT_TIMESTAMP, current_task_id=NULLIF($2, 0)
+		WHERE id=$1`, workerID, currentTaskID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrWorkerNotFound
+	}
+	return nil
+}
+
+// ListWorkers returns every registered worker, most recently seen first. A
+// worker is stale once staleAfter has passed since its last heartbeat, and
+// dead after deadAfter.
+func ListWorkers(ctx context.Context, db *pgxpool.Pool, staleAfter, deadAfter time.Duration) ([]models.Worker, error) {
+	rows, err := db.Query(ctx, `
+		SELECT id, language, host, task_types, version, COALESCE(current_task_id, 0),
+		       started_at, last_heartbeat_at,
+		       CASE
+		           WHEN last_heartbeat_at > CURRENT_TIMESTAMP - make_interval(secs => $1) THEN $3
+		           WHEN last_heartbeat_at > CURRENT_TIMESTAMP - make_interval(secs => $2) THEN $4
+		           ELSE $5
+		       END
+		FROM workers
+		ORDER BY last_heartbeat_at DESC, id`,
+		staleAfter.Seconds(), deadAfter.Seconds(), WorkerHealthy, WorkerStale, WorkerDead)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workers := []models.Worker{}
+	for rows.Next() {
+		var w models.Worker
+		if err := rows.Scan(&w.ID, &w.Language, &w.Host, &w.TaskTypes, &w.Version,
+			&w.CurrentTaskID, &w.StartedAt, &w.LastHeartbeatAt, &w.Health); err != nil {
+			return nil, err
+		}
+		workers = append(workers, w)
+	}
+	return workers, rows.Err()
+}
@@ -0,0 +1,248 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"taskqueue/internal/models"
+)
+
+// workflowColumns aggregates task counts for rows of workflows w joined with
+// tasks t and grouped by w.id; it is read by scanWorkflow.
+const workflowColumns = `w.id, w.user_id, w.name, w.created_at,
+        COUNT(t.id),
+        COUNT(t.id) FILTER (WHERE t.status IN ('pending', 'blocked', 'queued', 'processing', 'retrying')),
+        COUNT(t.id) FILTER (WHERE t.status = 'completed'),
+        COUNT(t.id) FILTER (WHERE t.status IN ('failed', 'timed_out')),
+        COUNT(t.id) FILTER (WHERE t.status = 'skipped'),
+        COUNT(t.id) FILTER (WHERE t.status = 'cancelled')`
+
+func scanWorkflow(row pgx.Row) (*models.Workflow, error) {
+	var w models.Workflow
+	if err := row.Scan(&w.ID, &w.UserID, &w.Name, &w.CreatedAt,
+		&w.Total, &w.Active, &w.Completed, &w.Failed, &w.Skipped, &w.Cancelled); err != nil {
+		return nil, err
+	}
+	w.Status = workflowStatus(&w)
+	return &w, nil
+}
+
+// workflowStatus derives a workflow's status from its task counts: running
+// while any task can still run, then failed if any task failed or timed out,
+// completed if every task completed, and cancelled otherwise.
+func workflowStatus(w *models.Workflow) string {
+	switch {
+	case w.Active > 0:
+		return "running"
+	case w.Failed > 0:
+		return "failed"
+	case w.Completed == w.Total:
+		return "completed"
+	default:
+		return "cancelled"
+	}
+}
+
+// CreateWorkflow inserts a workflow with its tasks and dependency edges in one
+// transaction. parents[i] lists the indexes in tasks that tasks[i] depends on;
+// the caller must ensure the graph is acyclic. Tasks without parents are
+// scheduled through the outbox, the rest wait in 'blocked'.
+func CreateWorkflow(ctx context.Context, db *pgxpool.Pool, w *models.Workflow, tasks []*models.Task, parents [][]int) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx, `
+			INSERT INTO workflows (user_id, name) VALUES ($1, $2)
+			RETURNING id, created_at`, w.UserID, w.Name).Scan(&w.ID, &w.CreatedAt); err != nil {
+			return err
+		}
+
+		for i, t := range tasks {
+			t.WorkflowID = w.ID
+			t.Status = "pending"
+			if len(parents[i]) > 0 {
+				t.Status = "blocked"
+			}
+			if err := insertTask(ctx, tx, t); err != nil {
+				return err
+			}
+		}
+
+		for i, t := range tasks {
+			t.DependsOn = make([]int64, 0, len(parents[i]))
+			for _, p := range parents[i] {
+				if _, err := tx.Exec(ctx, `
+					INSERT INTO task_dependencies (task_id, depends_on_id) VALUES ($1, $2)`,
+					t.ID, tasks[p].ID); err != nil {
+					return err
+				}
+				t.DependsOn = append(t.DependsOn, tasks[p].ID)
+			}
+			if len(parents[i]) == 0 {
+				if err := insertOutbox(ctx, tx, t.ID, 0); err != nil {
+					return err
+				}
+			}
+		}
+
+		w.Total, w.Active = len(tasks), len(tasks)
+		w.Status = workflowStatus(w)
+		return nil
+	})
+}
+
+// GetWorkflow returns a workflow with its aggregate status
+func GetWorkflow(ctx context.Context, db *pgxpool.Pool, workflowID, userID int64) (*models.Workflow, error) {
+	return scanWorkflow(db.QueryRow(ctx, `
+		SELECT `+workflowColumns+`
+		FROM workflows w LEFT JOIN tasks t ON t.workflow_id = w.id
+		WHERE w.id=$1 AND w.user_id=$2
+		GROUP BY w.id`, workflowID, userID))
+}
+
+// ListWorkflows returns a user's workflows, newest first
+func ListWorkflows(ctx context.Context, db *pgxpool.Pool, userID int64, limit, offset int) ([]models.Workflow, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+workflowColumns+`
+		FROM workflows w LEFT JOIN tasks t ON t.workflow_id = w.id
+		WHERE w.user_id=$1
+		GROUP BY w.id
+		ORDER BY w.created_at DESC, w.id DESC
+		LIMIT $2 OFFSET $3`, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workflows := []models.Workflow{}
+	for rows.Next() {
+		w, err := scanWorkflow(rows)
+		if err != nil {
+			return nil, err
+		}
+		workflows = append(workflows, *w)
+	}
+	return workflows, rows.Err()
+}
+
+// ListWorkflowTasks returns the tasks of a workflow, in creation order, with
+// DependsOn filled in
+func ListWorkflowTasks(ctx context.Context, db *pgxpool.Pool, workflowID int64) ([]models.Task, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+taskColumns+` FROM tasks WHERE workflow_id=$1 ORDER BY id`, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []models.Task{}
+	index := map[int64]int{}
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		t.DependsOn = []int64{}
+		index[t.ID] = len(tasks)
+		tasks = append(tasks, *t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	deps, err := db.Query(ctx, `
+		SELECT d.task_id, d.depends_on_id
+		FROM task_dependencies d JOIN tasks t ON t.id = d.task_id
+		WHERE t.workflow_id=$1 ORDER BY d.task_id, d.depends_on_id`, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer deps.Close()
+
+	for deps.Next() {
+		var taskID, dependsOn int64
+		if err := deps.Scan(&taskID, &dependsOn); err != nil {
+			return nil, err
+		}
+		if i, ok := index[taskID]; ok {
+			tasks[i].DependsOn = append(tasks[i].DependsOn, dependsOn)
+		}
+	}
+	return tasks, deps.Err()
+}
+
+// releaseDependents moves blocked children of a completed task to pending
+// once all of their parents have completed, and schedules them through the
+// outbox. The children are locked first so that parents completing
+// concurrently serialize and the last one to commit releases the child.
+func releaseDependents(ctx context.Context, tx pgx.Tx, taskID int64) error {
+	rows, err := tx.Query(ctx, `
+		SELECT t.id FROM tasks t JOIN task_dependencies d ON d.task_id = t.id
+		WHERE d.depends_on_id=$1 AND t.status='blocked'
+		ORDER BY t.id
+		FOR UPDATE OF t`, taskID)
+	if err != nil {
+		return err
+	}
+	children, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil || len(children) == 0 {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		WITH released AS (
+			UPDATE tasks c SET status='pending'
+			WHERE c.id = ANY($1) AND c.status='blocked'
+			AND NOT EXISTS (
+				SELECT 1 FROM task_dependencies d JOIN tasks p ON p.id = d.depends_on_id
+				WHERE d.task_id = c.id AND p.status <> 'completed'
+			)
+			RETURNING c.id
+		)
+		INSERT INTO task_outbox (task_id, available_at)
+		SELECT id, CURRENT_TIMESTAMP FROM released`, children)
+	return err
+}
+
+// skipDependents marks every blocked descendant of a task as skipped, with
+// reason as its error message.
+func skipDependents(ctx context.Context, tx pgx.Tx, taskID int64, reason string) error {
+	_, err := tx.Exec(ctx, `
+		WITH RECURSIVE descendants AS (
+			SELECT task_id FROM task_dependencies WHERE depends_on_id=$1
+			UNION
+			SELECT d.task_id FROM task_dependencies d
+			JOIN descendants ON d.depends_on_id = descendants.task_id
+		)
+		UPDATE tasks SET status='skipped', error_message=$2, completed_at=CURRENT_TIMESTAMP
+		WHERE id IN (SELECT task_id FROM descendants) AND status='blocked'`,
+		taskID, reason)
+	return err
+}
+
+// unskipDependents returns skipped descendants of a task to blocked, so they
+// run again once the task is retried and completes. A descendant stays skipped
+// while another of its parents is failed, timed out, cancelled or skipped.
+func unskipDependents(ctx context.Context, tx pgx.Tx, taskID int64) error {
+	ids := []int64{taskID}
+	for len(ids) > 0 {
+		rows, err := tx.Query(ctx, `
+			UPDATE tasks c SET status='blocked', error_message=NULL, completed_at=NULL
+			WHERE c.status='skipped'
+			AND EXISTS (
+				SELECT 1 FROM task_dependencies d
+				WHERE d.task_id = c.id AND d.depends_on_id = ANY($1)
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM task_dependencies d JOIN tasks p ON p.id = d.depends_on_id
+				WHERE d.task_id = c.id AND p.status IN ('failed', 'timed_out', 'cancelled', 'skipped')
+			)
+			RETURNING c.id`, ids)
+		if err != nil {
+			return err
+		}
+		if ids, err = pgx.CollectRows(rows, pgx.RowTo[int64]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/database"
+	"taskqueue/internal/outbox"
+	"taskqueue/internal/queue"
+	"taskqueue/pkg/logger"
+)
+
+// AdminHandler provides HTTP handlers for queue administration.
+type AdminHandler struct {
+	DB    *pgxpool.Pool
+	Q     queue.Broker
+	Relay *outbox.Relay
+
+	// WorkerStaleAfter and WorkerDeadAfter are how long after its last
+	// heartbeat a worker is shown as stale and as dead.
+	WorkerStaleAfter time.Duration
+	WorkerDeadAfter  time.Duration
+}
+
+// QueueStats handles GET /api/admin/queue to report broker and dead-letter depth.
+func (h *AdminHandler) QueueStats(c *gin.Context) {
+	attrs, err := h.Q.GetQueueAttributes(c.Request.Context())
+	if err != nil {
+		logger.Error("get queue attributes:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get queue attributes"})
+		return
+	}
+
+	deadLetters, err := database.CountDeadLetters(c.Request.Context(), h.DB)
+	if err != nil {
+		logger.Error("count dead letters:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count dead letters"})
+		return
+	}
+
+	stats := gin.H{
+		"visible":      attrs[queue.AttrVisible],
+		"in_flight":    attrs[queue.AttrInFlight],
+		"delayed":      attrs[queue.AttrDelayed],
+		"dead_letters": deadLetters,
+	}
+
+	if c.GetHeader("HX-Request") != "" {
+		c.HTML(http.StatusOK, "partials/queue.html", stats)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// ListWorkers handles GET /api/admin/workers to list registered workers
+// with their health.
+func (h *AdminHandler) ListWorkers(c *gin.Context) {
+	workers, err := database.ListWorkers(c.Request.Context(), h.DB, h.WorkerStaleAfter, h.WorkerDeadAfter)
+	if err != nil {
+		logger.Error("list workers:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list workers"})
+		return
+	}
+
+	if c.GetHeader("HX-Request") != "" {
+		c.HTML(http.StatusOK, "partials/workers.html", workers)
+		return
+	}
+	c.JSON(http.StatusOK, workers)
+}
+
+// ListDeadLetters handles GET /api/admin/dead-letters to list dead-lettered messages.
+func (h *AdminHandler) ListDeadLetters(c *gin.Context) {
+	limit, offset := 50, 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	letters, err := database.ListDeadLetters(c.Request.Context(), h.DB, limit, offset)
+	if err != nil {
+		logger.Error("list dead letters:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list dead letters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, letters)
+}
+
+// RequeueDeadLetter handles POST /api/admin/dead-letters/:id/requeue. A dead
+// letter without a task cannot be requeued and gets 422.
+func (h *AdminHandler) RequeueDeadLetter(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dead letter id"})
+		return
+	}
+
+	err = database.RequeueDeadLetter(c.Request.Context(), h.DB, id)
+	if errors.Is(err, database.ErrDeadLetterNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, database.ErrDeadLetterNoTask) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error("requeue dead letter:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to requeue dead letter"})
+		return
+	}
+	if h.Relay != nil {
+		h.Relay.Notify()
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "dead letter requeued"})
+}
+
+// RequeueAllDeadLetters handles POST /api/admin/dead-letters/requeue. Dead
+// letters without a task are left in place.
+func (h *AdminHandler) RequeueAllDeadLetters(c *gin.Context) {
+	n, err := database.RequeueAllDeadLetters(c.Request.Context(), h.DB)
+	if n > 0 && h.Relay != nil {
+		h.Relay.Notify()
+	}
+	if err != nil {
+		logger.Error("requeue dead letters:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to requeue dead letters", "requeued": n})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"requeued": n})
+}
+
+// PurgeDeadLetter handles DELETE /api/admin/dead-letters/:id.
+func (h *AdminHandler) PurgeDeadLetter(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dead letter id"})
+		return
+	}
+
+	err = database.PurgeDeadLetter(c.Request.Context(), h.DB, id)
+	if errors.Is(err, database.ErrDeadLetterNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error("purge dead letter:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to purge dead letter"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "dead letter purged"})
+}
+
+// PurgeDeadLetters handles DELETE /api/admin/dead-letters.
+func (h *AdminHandler) PurgeDeadLetters(c *gin.Context) {
+	n, err := database.PurgeDeadLetters(c.Request.Context(), h.DB)
+	if err != nil {
+		logger.Error("purge dead letters:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to purge dead letters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purged": n})
+}
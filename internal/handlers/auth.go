@@ -6,8 +6,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5/pgxpool"
-	
+
 	"taskqueue/internal/auth"
 	"taskqueue/internal/database"
 	"taskqueue/pkg/logger"
@@ -15,9 +14,9 @@ import (
 
 // AuthHandler handles authentication routes
 type AuthHandler struct {
-	DB           *pgxpool.Pool
+	Users         database.UserStore
 	OAuthProvider *auth.OAuthProvider
-	JWTSecret    string
+	JWTSecret     string
 }
 
 // GoogleLogin initiates Google OAuth flow
@@ -74,7 +73,7 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 	}
 
 	// Create or update user
-	userID, err := database.CreateUser(ctx, h.DB, userInfo.ID, userInfo.Email, userInfo.Name, userInfo.Picture)
+	userID, err := h.Users.UpsertUser(ctx, userInfo.ID, userInfo.Email, userInfo.Name, userInfo.Picture)
 	if err != nil {
 		logger.Error("failed to create user", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
@@ -112,24 +111,20 @@ func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
 
 	ctx := context.Background()
 	var user struct {
-		ID      int64     `json:"id"`
-		Email   string    `json:"email"`
-		Name    string    `json:"name"`
-		Picture string    `json:"picture"`
+		ID        int64     `json:"id"`
+		Email     string    `json:"email"`
+		Name      string    `json:"name"`
+		Picture   string    `json:"picture"`
 		CreatedAt time.Time `json:"created_at"`
 	}
 
-	err := h.DB.QueryRow(ctx, `
-		SELECT id, email, name, picture, created_at
-		FROM users WHERE id = $1`, userID).Scan(
-		&user.ID, &user.Email, &user.Name, &user.Picture, &user.CreatedAt,
-	)
-
+	u, err := h.Users.GetUser(ctx, userID.(int64))
 	if err != nil {
 		logger.Error("failed to get user", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
 		return
 	}
+	user.ID, user.Email, user.Name, user.Picture, user.CreatedAt = u.ID, u.Email, u.Name, u.Picture, u.CreatedAt
 
 	c.JSON(http.StatusOK, user)
-}
\ No newline at end of file
+}
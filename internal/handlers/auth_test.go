@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"taskqueue/internal/database"
+)
+
+func newAuthRouter(store *database.MemoryStore) http.Handler {
+	h := &AuthHandler{Users: store, JWTSecret: "test"}
+	r := newTestRouter()
+	r.GET("/api/user", h.GetCurrentUser)
+	r.GET("/auth/google/callback", h.GoogleCallback)
+	r.GET("/auth/logout", h.Logout)
+	return r
+}
+
+func TestGetCurrentUser(t *testing.T) {
+	store := database.NewMemoryStore()
+	ctx := context.Background()
+	id, err := store.UpsertUser(ctx, "g1", "old@example.com", "Ann", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Logging in again updates the same user.
+	if again, err := store.UpsertUser(ctx, "g1", "ann@example.com", "Ann", "pic"); err != nil || again != id {
+		t.Fatalf("second upsert returned %d, %v; want %d", again, err, id)
+	}
+	r := newAuthRouter(store)
+
+	var user struct {
+		ID      int64  `json:"id"`
+		Email   string `json:"email"`
+		Picture string `json:"picture"`
+	}
+	if code := serve(t, r, http.MethodGet, "/api/user", id, "", nil, &user); code != http.StatusOK {
+		t.Fatalf("get user returned %d", code)
+	}
+	if user.ID != id || user.Email != "ann@example.com" || user.Picture != "pic" {
+		t.Errorf("got %+v", user)
+	}
+
+	if code := serve(t, r, http.MethodGet, "/api/user", 0, "", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated get returned %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := serve(t, r, http.MethodGet, "/api/user", id+1, "", nil, nil); code != http.StatusInternalServerError {
+		t.Errorf("unknown user get returned %d, want %d", code, http.StatusInternalServerError)
+	}
+}
+
+func TestGoogleCallbackRejectsBadState(t *testing.T) {
+	r := newAuthRouter(database.NewMemoryStore())
+	tests := []struct {
+		name   string
+		query  string
+		cookie string
+	}{
+		{"no cookie", "?state=s&code=c", ""},
+		{"mismatch", "?state=s&code=c", "other"},
+		{"no state", "?code=c", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth/google/callback"+tt.query, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "oauth_state", Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("code = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestLogoutClearsCookie(t *testing.T) {
+	r := newAuthRouter(database.NewMemoryStore())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/logout", nil))
+
+	if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "/login" {
+		t.Errorf("got %d to %q, want redirect to /login", w.Code, w.Header().Get("Location"))
+	}
+	if cookie := w.Header().Get("Set-Cookie"); !strings.HasPrefix(cookie, "auth_token=;") || !strings.Contains(cookie, "Max-Age=0") {
+		t.Errorf("Set-Cookie = %q, want auth_token cleared", cookie)
+	}
+}
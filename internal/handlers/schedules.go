@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/database"
+	"taskqueue/internal/models"
+	"taskqueue/internal/scheduler"
+	"taskqueue/pkg/logger"
+)
+
+// ScheduleHandler provides HTTP handlers for scheduled and recurring tasks.
+type ScheduleHandler struct {
+	DB *pgxpool.Pool
+}
+
+// Create handles POST /api/schedules to schedule a task once at run_at or
+// repeatedly from a cron expression evaluated in UTC.
+func (h *ScheduleHandler) Create(c *gin.Context) {
+	var req struct {
+		taskRequest
+		RunAt *time.Time `json:"run_at"`
+		Cron  string     `json:"cron"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.RunAt == nil) == (req.Cron == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of run_at and cron is required"})
+		return
+	}
+
+	userIDInterface, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDInterface.(int64)
+
+	task := req.task(userID)
+	schedule := &models.Schedule{
+		UserID:         userID,
+		Name:           task.Name,
+		Type:           task.Type,
+		Priority:       task.Priority,
+		Payload:        task.Payload,
+		MaxAttempts:    task.MaxAttempts,
+		Backoff:        task.Backoff,
+		BackoffSeconds: task.BackoffSeconds,
+		TimeoutSeconds: task.TimeoutSeconds,
+		Cron:           req.Cron,
+	}
+	if req.RunAt != nil {
+		schedule.RunAt = req.RunAt.UTC()
+		schedule.NextRunAt = schedule.RunAt
+	} else {
+		cron, err := scheduler.ParseCron(req.Cron)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		schedule.NextRunAt = cron.Next(time.Now().UTC())
+		if schedule.NextRunAt.IsZero() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cron expression never matches"})
+			return
+		}
+	}
+
+	if err := database.CreateSchedule(c.Request.Context(), h.DB, schedule); err != nil {
+		logger.Error("create schedule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create schedule"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, schedule)
+}
+
+// List handles GET /api/schedules to list the user's schedules.
+func (h *ScheduleHandler) List(c *gin.Context) {
+	userIDInterface, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDInterface.(int64)
+
+	limit, offset := 50, 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	schedules, err := database.ListSchedules(c.Request.Context(), h.DB, userID, limit, offset)
+	if err != nil {
+		logger.Error("list schedules:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list schedules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedules)
+}
+
+// Get handles GET /api/schedules/:id to get a single schedule.
+func (h *ScheduleHandler) Get(c *gin.Context) {
+	userIDInterface, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDInterface.(int64)
+
+	scheduleID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schedule id"})
+		return
+	}
+
+	schedule, err := database.GetSchedule(c.Request.Context(), h.DB, scheduleID, userID)
+	if errors.Is(err, database.ErrScheduleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error("get schedule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+// Delete handles DELETE /api/schedules/:id to stop and remove a schedule.
+func (h *ScheduleHandler) Delete(c *gin.Context) {
+	userIDInterface, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDInterface.(int64)
+
+	scheduleID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid schedule id"})
+		return
+	}
+
+	err = database.DeleteSchedule(c.Request.Context(), h.DB, scheduleID, userID)
+	if errors.Is(err, database.ErrScheduleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error("delete schedule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "schedule deleted"})
+}
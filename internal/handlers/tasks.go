@@ -1,35 +1,129 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5/pgxpool"
 
 	"taskqueue/internal/database"
 	"taskqueue/internal/models"
-	"taskqueue/internal/queue"
+	"taskqueue/internal/outbox"
 	"taskqueue/internal/websocket"
 	"taskqueue/pkg/logger"
 )
 
+// maxIdempotencyKeyLen is the longest Idempotency-Key header accepted.
+const maxIdempotencyKeyLen = 255
+
+// Retry policy applied when a create request does not set one.
+const (
+	defaultMaxAttempts    = 3
+	defaultBackoff        = "exponential"
+	defaultBackoffSeconds = 10
+)
+
+// maxTimeoutSeconds is the longest timeout a task may ask for, the most an
+// SQS message can be kept invisible.
+const maxTimeoutSeconds = 12 * 60 * 60
+
 // TaskHandler provides HTTP handlers for task operations.
 type TaskHandler struct {
-	DB  *pgxpool.Pool
-	Q   *queue.Client
-	Hub *websocket.Hub
+	Tasks database.TaskStore
+	Relay *outbox.Relay
+	Hub   *websocket.Hub
+
+	// IdempotencyTTL is how long an Idempotency-Key is remembered.
+	IdempotencyTTL time.Duration
+
+	// TaskTimeouts are the default timeouts by task type, for tasks created
+	// without one.
+	TaskTimeouts map[string]time.Duration
+}
+
+// taskRequest is the body accepted when creating a task.
+type taskRequest struct {
+	Name     string          `json:"name" form:"name" binding:"required"`
+	Type     string          `json:"type" form:"type" binding:"required"`
+	Priority string          `json:"priority" form:"priority" binding:"required,oneof=low medium high"`
+	Payload  json.RawMessage `json:"payload" form:"payload"`
+
+	MaxAttempts    int    `json:"max_attempts" form:"max_attempts" binding:"omitempty,min=1,max=25"`
+	Backoff        string `json:"backoff" form:"backoff" binding:"omitempty,oneof=fixed linear exponential"`
+	BackoffSeconds *int   `json:"backoff_seconds" form:"backoff_seconds" binding:"omitempty,min=0,max=86400"`
+
+	TimeoutSeconds int `json:"timeout_seconds" form:"timeout_seconds" binding:"omitempty,min=1,max=43200"`
+}
+
+// task builds a pending task owned by userID, applying the default retry
+// policy for fields the request leaves unset.
+func (req *taskRequest) task(userID int64) *models.Task {
+	task := &models.Task{
+		UserID:   userID,
+		Name:     req.Name,
+		Type:     req.Type,
+		Priority: req.Priority,
+		Status:   "pending",
+		Payload:  req.Payload,
+
+		MaxAttempts:    defaultMaxAttempts,
+		Backoff:        defaultBackoff,
+		BackoffSeconds: defaultBackoffSeconds,
+		TimeoutSeconds: req.TimeoutSeconds,
+	}
+	if req.MaxAttempts > 0 {
+		task.MaxAttempts = req.MaxAttempts
+	}
+	if req.Backoff != "" {
+		task.Backoff = req.Backoff
+	}
+	if req.BackoffSeconds != nil {
+		task.BackoffSeconds = *req.BackoffSeconds
+	}
+	return task
+}
+
+// applyTimeout gives a task created without a timeout the default for its
+// type in timeouts, if any.
+func applyTimeout(task *models.Task, timeouts map[string]time.Duration) {
+	if task.TimeoutSeconds > 0 {
+		return
+	}
+	if d := timeouts[task.Type]; d > 0 {
+		task.TimeoutSeconds = min(int((d+time.Second-1)/time.Second), maxTimeoutSeconds)
+	}
 }
 
-// Create handles POST /api/tasks to create and enqueue a task.
+// Create handles POST /api/tasks to create a task and schedule it for the
+// queue. With an Idempotency-Key header, a repeat of an earlier request
+// returns the task it created instead of creating another.
 func (h *TaskHandler) Create(c *gin.Context) {
-	var req struct {
-		Name     string          `json:"name" form:"name" binding:"required"`
-		Type     string          `json:"type" form:"type" binding:"required"`
-		Priority string          `json:"priority" form:"priority" binding:"required,oneof=low medium high"`
-		Payload  json.RawMessage `json:"payload" form:"payload"`
+	idemKey := c.GetHeader("Idempotency-Key")
+	var requestHash string
+	if idemKey != "" {
+		if len(idemKey) > maxIdempotencyKeyLen {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		requestHash = hex.EncodeToString(sum[:])
 	}
+
+	var req taskRequest
 	if err := c.ShouldBind(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -42,56 +136,119 @@ func (h *TaskHandler) Create(c *gin.Context) {
 	}
 	userID := userIDInterface.(int64)
 
-	task := &models.Task{
-		UserID:   userID,
-		Name:     req.Name,
-		Type:     req.Type,
-		Priority: req.Priority,
-		Status:   "pending",
-		Payload:  req.Payload,
+	if idemKey != "" && h.replayIdempotent(c, userID, idemKey, requestHash) {
+		return
 	}
+
+	task := req.task(userID)
 	
-	if err := database.CreateTask(c.Request.Context(), h.DB, task); err != nil {
+	var err error
+	if idemKey != "" {
+		err = h.submit(c.Request.Context(), task, &database.IdempotencyKey{
+			UserID:      userID,
+			Key:         idemKey,
+			RequestHash: requestHash,
+			StatusCode:  http.StatusAccepted,
+			TTL:         h.IdempotencyTTL,
+		})
+		if errors.Is(err, database.ErrIdempotencyKeyExists) {
+			// A concurrent request with the same key won the race.
+			if !h.replayIdempotent(c, userID, idemKey, requestHash) {
+				c.JSON(http.StatusConflict, gin.H{"error": "request with this Idempotency-Key is in progress"})
+			}
+			return
+		}
+	} else {
+		err = h.Submit(c.Request.Context(), task)
+	}
+	if err != nil {
 		logger.Error("create task:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create task"})
 		return
 	}
 
-	// Enqueue task to SQS with priority
-	queueMessage := map[string]interface{}{
-		"task_id": task.ID,
-		"type":    task.Type,
-		"payload": req.Payload,
+	h.respondCreated(c, http.StatusAccepted, task)
+}
+
+// replayIdempotent answers a request whose Idempotency-Key the user has
+// already used: with the original task and status code if the body matches,
+// or 422 if it does not. It returns false if the key is unused.
+func (h *TaskHandler) replayIdempotent(c *gin.Context, userID int64, key, requestHash string) bool {
+	prior, err := h.Tasks.GetIdempotencyKey(c.Request.Context(), userID, key)
+	if errors.Is(err, database.ErrIdempotencyKeyNotFound) {
+		return false
 	}
-	messageBody, _ := json.Marshal(queueMessage)
-	
-	msgID, err := h.Q.EnqueueWithPriority(c.Request.Context(), string(messageBody), req.Priority)
 	if err != nil {
-		logger.Error("queue error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "queue error"})
-		return
+		logger.Error("get idempotency key:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create task"})
+		return true
 	}
-	
-	// Update task with message ID
-	if err := database.UpdateTaskStatus(c.Request.Context(), h.DB, task.ID, "queued", msgID); err != nil {
-		logger.Error("update task status:", err)
+
+	if prior.RequestHash != requestHash {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request body"})
+		return true
 	}
-	task.MessageID = msgID
-	task.Status = "queued"
 
-	// Broadcast task creation via WebSocket
-	if h.Hub != nil {
-		h.Hub.BroadcastToUser(userID, "task_created", task)
+	task, err := h.Tasks.GetTask(c.Request.Context(), prior.TaskID, userID)
+	if err != nil {
+		logger.Error("get idempotent task:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create task"})
+		return true
 	}
+	h.respondCreated(c, prior.StatusCode, task)
+	return true
+}
 
+func (h *TaskHandler) respondCreated(c *gin.Context, status int, task *models.Task) {
 	if c.GetHeader("HX-Request") != "" {
-		c.HTML(http.StatusAccepted, "partials/row.html", task)
+		c.HTML(status, "partials/row.html", task)
 		return
 	}
-	c.JSON(http.StatusAccepted, task)
+	c.JSON(status, task)
 }
 
-// List handles GET /api/tasks to list tasks for the user.
+// Submit stores a pending task and schedules it for the queue. The task and
+// its outbox row are written together; the relay publishes it to the queue
+// and marks it queued. Submit is shared by Create and the scheduler.
+func (h *TaskHandler) Submit(ctx context.Context, task *models.Task) error {
+	return h.submit(ctx, task, nil)
+}
+
+// submit is Submit that, if key is not nil, also records the idempotency key
+// with the task.
+func (h *TaskHandler) submit(ctx context.Context, task *models.Task, key *database.IdempotencyKey) error {
+	applyTimeout(task, h.TaskTimeouts)
+
+	var err error
+	if key != nil {
+		err = h.Tasks.CreateTaskWithIdempotencyKey(ctx, task, key)
+	} else {
+		err = h.Tasks.CreateTask(ctx, task)
+	}
+	if err != nil {
+		return err
+	}
+	if h.Relay != nil {
+		h.Relay.Notify()
+	}
+
+	// Broadcast task creation via WebSocket
+	if h.Hub != nil {
+		h.Hub.Broadcast(&websocket.Event{
+			UserID:     task.UserID,
+			Type:       "task_created",
+			TaskID:     task.ID,
+			WorkflowID: task.WorkflowID,
+			TaskType:   task.Type,
+			Data:       task,
+		})
+	}
+	return nil
+}
+
+// List handles GET /api/tasks to search the user's tasks, newest first unless
+// sort and order say otherwise. Pages are fetched with the next_cursor or
+// prev_cursor of the previous response passed back as cursor.
 func (h *TaskHandler) List(c *gin.Context) {
 	userIDInterface, exists := c.Get("user_id")
 	if !exists {
@@ -105,8 +262,9 @@ func (h *TaskHandler) List(c *gin.Context) {
 		Status:   c.Query("status"),
 		Type:     c.Query("type"),
 		Priority: c.Query("priority"),
+		Search:   c.Query("q"),
+		Sort:     c.Query("sort"),
 		Limit:    50,
-		Offset:   0,
 	}
 
 	if limitStr := c.Query("limit"); limitStr != "" {
@@ -115,13 +273,64 @@ func (h *TaskHandler) List(c *gin.Context) {
 		}
 	}
 
-	if offsetStr := c.Query("offset"); offsetStr != "" {
-		if offset, err := strconv.Atoi(offsetStr); err == nil && offset >= 0 {
-			filter.Offset = offset
+	for _, r := range []struct {
+		param string
+		dst   *time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+		{"completed_after", &filter.CompletedAfter},
+		{"completed_before", &filter.CompletedBefore},
+	} {
+		if v := c.Query(r.param); v != "" {
+			t, err := parseTimeParam(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + r.param})
+				return
+			}
+			*r.dst = t
+		}
+	}
+
+	for _, r := range []struct {
+		param string
+		dst   *[]byte
+	}{
+		{"payload", &filter.Payload},
+		{"result", &filter.Result},
+	} {
+		if v := c.Query(r.param); v != "" {
+			if !json.Valid([]byte(v)) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": r.param + " must be a JSON document"})
+				return
+			}
+			*r.dst = []byte(v)
+		}
+	}
+
+	switch c.Query("order") {
+	case "", "desc":
+	case "asc":
+		filter.Asc = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+
+	if cursorStr := c.Query("cursor"); cursorStr != "" {
+		cursor, err := database.DecodeCursor(cursorStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
+		filter.Cursor = cursor
 	}
 
-	tasks, err := database.ListTasks(c.Request.Context(), h.DB, userID, filter)
+	page, err := h.Tasks.ListTasks(c.Request.Context(), userID, filter)
+	if errors.Is(err, database.ErrInvalidSort) || errors.Is(err, database.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		logger.Error("list tasks:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list tasks"})
@@ -129,13 +338,54 @@ func (h *TaskHandler) List(c *gin.Context) {
 	}
 
 	if c.GetHeader("HX-Request") != "" {
-		c.HTML(http.StatusOK, "partials/rows.html", tasks)
+		// The rows end with a row that loads the next page when scrolled
+		// into view, carrying the same filters.
+		var moreURL string
+		if page.NextCursor != "" {
+			query := c.Request.URL.Query()
+			query.Set("cursor", page.NextCursor)
+			moreURL = "/api/tasks?" + query.Encode()
+		}
+		c.HTML(http.StatusOK, "partials/rows.html", gin.H{
+			"Tasks":     page.Tasks,
+			"MoreURL":   moreURL,
+			"FirstPage": filter.Cursor == nil,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// timeParamLayouts are the formats accepted for time filters: RFC 3339, and
+// the datetime-local and date inputs of the dashboard, taken as UTC.
+var timeParamLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"}
+
+func parseTimeParam(v string) (time.Time, error) {
+	var err error
+	for _, layout := range timeParamLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, v); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// Events handles GET /api/tasks/events to stream the user's task events as
+// Server-Sent Events, for clients that cannot open a WebSocket.
+func (h *TaskHandler) Events(c *gin.Context) {
+	userIDInterface, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
-	c.JSON(http.StatusOK, tasks)
+	userID := userIDInterface.(int64)
+
+	h.Hub.ServeEvents(c.Writer, c.Request, userID)
 }
 
-// Get handles GET /api/tasks/:id to get a single task.
+// Get handles GET /api/tasks/:id to get a single task with its attempt
+// history, or its table row for htmx requests.
 func (h *TaskHandler) Get(c *gin.Context) {
 	userIDInterface, exists := c.Get("user_id")
 	if !exists {
@@ -151,16 +401,35 @@ func (h *TaskHandler) Get(c *gin.Context) {
 		return
 	}
 
-	task, err := database.GetTask(c.Request.Context(), h.DB, taskID, userID)
+	task, err := h.Tasks.GetTask(c.Request.Context(), taskID, userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, task)
+	// The dashboard refreshes a single row when the task changes.
+	if c.GetHeader("HX-Request") != "" {
+		c.HTML(http.StatusOK, "partials/row.html", task)
+		return
+	}
+
+	attempts, err := h.Tasks.ListTaskAttempts(c.Request.Context(), taskID)
+	if err != nil {
+		logger.Error("list task attempts:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get task"})
+		return
+	}
+
+	c.JSON(http.StatusOK, struct {
+		*models.Task
+		Attempts []models.TaskAttempt
+	}{task, attempts})
 }
 
-// Cancel handles DELETE /api/tasks/:id to cancel a task.
+// Cancel handles DELETE /api/tasks/:id to cancel a task. A task that is not
+// yet processing is cancelled at once. For a processing task cancellation is
+// only requested, with 202 Accepted; its worker stops it and ends it
+// cancelled.
 func (h *TaskHandler) Cancel(c *gin.Context) {
 	userIDInterface, exists := c.Get("user_id")
 	if !exists {
@@ -176,15 +445,38 @@ func (h *TaskHandler) Cancel(c *gin.Context) {
 		return
 	}
 
-	if err := database.CancelTask(c.Request.Context(), h.DB, taskID, userID); err != nil {
+	task, err := h.Tasks.CancelTask(c.Request.Context(), taskID, userID)
+	if err != nil {
 		logger.Error("cancel task:", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if task.Status == "processing" {
+		if h.Hub != nil {
+			h.Hub.Broadcast(&websocket.Event{
+				UserID:     userID,
+				Type:       "task_cancel_requested",
+				TaskID:     taskID,
+				WorkflowID: task.WorkflowID,
+				TaskType:   task.Type,
+				Data:       gin.H{"task_id": taskID},
+			})
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "task cancellation requested"})
+		return
+	}
+
 	// Broadcast task cancellation via WebSocket
 	if h.Hub != nil {
-		h.Hub.BroadcastToUser(userID, "task_cancelled", gin.H{"task_id": taskID})
+		h.Hub.Broadcast(&websocket.Event{
+			UserID:     userID,
+			Type:       "task_cancelled",
+			TaskID:     taskID,
+			WorkflowID: task.WorkflowID,
+			TaskType:   task.Type,
+			Data:       gin.H{"task_id": taskID},
+		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "task cancelled"})
@@ -199,12 +491,16 @@ func (h *TaskHandler) Stats(c *gin.Context) {
 	}
 	userID := userIDInterface.(int64)
 
-	stats, err := database.GetTaskStats(c.Request.Context(), h.DB, userID)
+	stats, err := h.Tasks.GetTaskStats(c.Request.Context(), userID)
 	if err != nil {
 		logger.Error("get task stats:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get stats"})
 		return
 	}
 
+	if c.GetHeader("HX-Request") != "" {
+		c.HTML(http.StatusOK, "partials/stats.html", stats)
+		return
+	}
 	c.JSON(http.StatusOK, stats)
 }
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"taskqueue/internal/database"
+	"taskqueue/internal/models"
+	"taskqueue/internal/websocket"
+)
+
+// testUserHeader names the user a test request is made as; the test router
+// sets it as user_id the way the auth middleware would.
+const testUserHeader = "X-Test-User"
+
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if v := c.GetHeader(testUserHeader); v != "" {
+			id, _ := strconv.ParseInt(v, 10, 64)
+			c.Set("user_id", id)
+		}
+	})
+	return r
+}
+
+func newTaskRouter(store *database.MemoryStore) *gin.Engine {
+	h := &TaskHandler{
+		Tasks:          store,
+		IdempotencyTTL: time.Hour,
+		TaskTimeouts:   map[string]time.Duration{"report": 90 * time.Second},
+	}
+	r := newTestRouter()
+	r.POST("/api/tasks", h.Create)
+	r.GET("/api/tasks", h.List)
+	r.GET("/api/tasks/stats", h.Stats)
+	r.GET("/api/tasks/:id", h.Get)
+	r.DELETE("/api/tasks/:id", h.Cancel)
+	return r
+}
+
+// serve makes a request as userID, or unauthenticated if userID is zero, and
+// decodes the JSON response into out if it is not nil.
+func serve(t *testing.T, r http.Handler, method, path string, userID int64, body string, header http.Header, out interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if userID != 0 {
+		req.Header.Set(testUserHeader, fmt.Sprint(userID))
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: bad response %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+func createTask(t *testing.T, r http.Handler, userID int64, body string) *models.Task {
+	t.Helper()
+	var task models.Task
+	if code := serve(t, r, http.MethodPost, "/api/tasks", userID, body, nil, &task); code != http.StatusAccepted {
+		t.Fatalf("create returned %d", code)
+	}
+	return &task
+}
+
+func TestCreateTask(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		code        int
+		maxAttempts int
+		timeout     int
+	}{
+		{
+			name:        "defaults",
+			body:        `{"name": "a", "type": "email", "priority": "high"}`,
+			code:        http.StatusAccepted,
+			maxAttempts: defaultMaxAttempts,
+		},
+		{
+			name:        "type timeout",
+			body:        `{"name": "a", "type": "report", "priority": "low"}`,
+			code:        http.StatusAccepted,
+			maxAttempts: defaultMaxAttempts,
+			timeout:     90,
+		},
+		{
+			name:        "own policy",
+			body:        `{"name": "a", "type": "report", "priority": "low", "max_attempts": 5, "timeout_seconds": 10}`,
+			code:        http.StatusAccepted,
+			maxAttempts: 5,
+			timeout:     10,
+		},
+		{
+			name: "bad priority",
+			body: `{"name": "a", "type": "email", "priority": "urgent"}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "missing name",
+			body: `{"type": "email", "priority": "high"}`,
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTaskRouter(database.NewMemoryStore())
+			var task models.Task
+			code := serve(t, r, http.MethodPost, "/api/tasks", 1, tt.body, nil, &task)
+			if code != tt.code {
+				t.Fatalf("code = %d, want %d", code, tt.code)
+			}
+			if code != http.StatusAccepted {
+				return
+			}
+			if task.ID == 0 || task.UserID != 1 || task.Status != "pending" {
+				t.Errorf("got task %d of user %d, %s", task.ID, task.UserID, task.Status)
+			}
+			if task.MaxAttempts != tt.maxAttempts || task.TimeoutSeconds != tt.timeout {
+				t.Errorf("max attempts %d, timeout %d; want %d, %d",
+					task.MaxAttempts, task.TimeoutSeconds, tt.maxAttempts, tt.timeout)
+			}
+		})
+	}
+}
+
+func TestCreateTaskUnauthenticated(t *testing.T) {
+	r := newTaskRouter(database.NewMemoryStore())
+	body := `{"name": "a", "type": "email", "priority": "high"}`
+	if code := serve(t, r, http.MethodPost, "/api/tasks", 0, body, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("code = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestCreateTaskIdempotent(t *testing.T) {
+	r := newTaskRouter(database.NewMemoryStore())
+	key := http.Header{"Idempotency-Key": {"k1"}}
+	body := `{"name": "a", "type": "email", "priority": "high"}`
+
+	var first, again models.Task
+	if code := serve(t, r, http.MethodPost, "/api/tasks", 1, body, key, &first); code != http.StatusAccepted {
+		t.Fatalf("first create returned %d", code)
+	}
+	if code := serve(t, r, http.MethodPost, "/api/tasks", 1, body, key, &again); code != http.StatusAccepted {
+		t.Fatalf("repeat returned %d", code)
+	}
+	if again.ID != first.ID {
+		t.Errorf("repeat created task %d, want %d", again.ID, first.ID)
+	}
+
+	other := `{"name": "b", "type": "email", "priority": "high"}`
+	if code := serve(t, r, http.MethodPost, "/api/tasks", 1, other, key, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("different body returned %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+
+	// Keys are per user.
+	var theirs models.Task
+	if code := serve(t, r, http.MethodPost, "/api/tasks", 2, body, key, &theirs); code != http.StatusAccepted {
+		t.Fatalf("other user's create returned %d", code)
+	}
+	if theirs.ID == first.ID {
+		t.Error("other user got the first user's task")
+	}
+}
+
+func TestGetTask(t *testing.T) {
+	r := newTaskRouter(database.NewMemoryStore())
+	created := createTask(t, r, 1, `{"name": "a", "type": "email", "priority": "high"}`)
+	path := fmt.Sprintf("/api/tasks/%d", created.ID)
+
+	var got struct {
+		models.Task
+		Attempts []models.TaskAttempt
+	}
+	if code := serve(t, r, http.MethodGet, path, 1, "", nil, &got); code != http.StatusOK {
+		t.Fatalf("get returned %d", code)
+	}
+	if got.ID != created.ID || got.Name != "a" || got.Attempts == nil {
+		t.Errorf("got task %d %q with attempts %v", got.ID, got.Name, got.Attempts)
+	}
+
+	if code := serve(t, r, http.MethodGet, path, 2, "", nil, nil); code != http.StatusNotFound {
+		t.Errorf("other user's get returned %d, want %d", code, http.StatusNotFound)
+	}
+	if code := serve(t, r, http.MethodGet, "/api/tasks/x", 1, "", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("bad id returned %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+// listIDs follows next_cursor from path to the last page and returns the IDs
+// of every task listed.
+func listIDs(t *testing.T, r http.Handler, userID int64, path string) []int64 {
+	t.Helper()
+	var ids []int64
+	for pages := 0; ; pages++ {
+		if pages > 20 {
+			t.Fatal("pagination does not end")
+		}
+		var page database.TaskPage
+		if code := serve(t, r, http.MethodGet, path, userID, "", nil, &page); code != http.StatusOK {
+			t.Fatalf("list %s returned %d", path, code)
+		}
+		for _, task := range page.Tasks {
+			ids = append(ids, task.ID)
+		}
+		if page.NextCursor == "" {
+			return ids
+		}
+		u, _ := url.Parse(path)
+		q := u.Query()
+		q.Set("cursor", page.NextCursor)
+		u.RawQuery = q.Encode()
+		path = u.String()
+	}
+}
+
+func TestListTasks(t *testing.T) {
+	store := database.NewMemoryStore()
+	r := newTaskRouter(store)
+	var ids []int64
+	for i, typ := range []string{"email", "data", "email", "data", "email"} {
+		body := fmt.Sprintf(`{"name": "task %d", "type": %q, "priority": "high"}`, i, typ)
+		ids = append(ids, createTask(t, r, 1, body).ID)
+	}
+	createTask(t, r, 2, `{"name": "theirs", "type": "email", "priority": "high"}`)
+
+	tests := []struct {
+		path string
+		want []int64
+	}{
+		{"/api/tasks", []int64{ids[4], ids[3], ids[2], ids[1], ids[0]}},
+		{"/api/tasks?limit=2", []int64{ids[4], ids[3], ids[2], ids[1], ids[0]}},
+		{"/api/tasks?limit=2&order=asc", ids},
+		{"/api/tasks?limit=1&type=data", []int64{ids[3], ids[1]}},
+		{"/api/tasks?q=task+2", []int64{ids[2]}},
+		{"/api/tasks?sort=name&order=asc&limit=3", ids},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := listIDs(t, r, 1, tt.path); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListTasksPrevCursor(t *testing.T) {
+	r := newTaskRouter(database.NewMemoryStore())
+	for i := 0; i < 5; i++ {
+		createTask(t, r, 1, fmt.Sprintf(`{"name": "task %d", "type": "email", "priority": "high"}`, i))
+	}
+
+	var first, second, back database.TaskPage
+	serve(t, r, http.MethodGet, "/api/tasks?limit=2", 1, "", nil, &first)
+	serve(t, r, http.MethodGet, "/api/tasks?limit=2&cursor="+first.NextCursor, 1, "", nil, &second)
+	if second.PrevCursor == "" {
+		t.Fatal("second page has no prev_cursor")
+	}
+	serve(t, r, http.MethodGet, "/api/tasks?limit=2&cursor="+second.PrevCursor, 1, "", nil, &back)
+
+	if len(back.Tasks) != 2 || back.Tasks[0].ID != first.Tasks[0].ID || back.Tasks[1].ID != first.Tasks[1].ID {
+		t.Errorf("paging back returned %v, want the first page", back.Tasks)
+	}
+}
+
+func TestListTasksBadRequest(t *testing.T) {
+	r := newTaskRouter(database.NewMemoryStore())
+	for _, path := range []string{
+		"/api/tasks?order=sideways",
+		"/api/tasks?sort=priority",
+		"/api/tasks?cursor=garbage",
+		"/api/tasks?created_after=yesterday",
+		"/api/tasks?payload=%7Bnot+json",
+	} {
+		if code := serve(t, r, http.MethodGet, path, 1, "", nil, nil); code != http.StatusBadRequest {
+			t.Errorf("%s returned %d, want %d", path, code, http.StatusBadRequest)
+		}
+	}
+}
+
+// recordedEvents is a websocket.EventStore that keeps the events a hub
+// broadcasts so tests can inspect them.
+type recordedEvents struct {
+	events []websocket.Event
+}
+
+func (r *recordedEvents) Append(ctx context.Context, e *websocket.Event) error {
+	e.ID = int64(len(r.events) + 1)
+	r.events = append(r.events, *e)
+	return nil
+}
+
+func (r *recordedEvents) Get(ctx context.Context, id int64) (*websocket.Event, error) {
+	e := r.events[id-1]
+	return &e, nil
+}
+
+func (r *recordedEvents) Since(ctx context.Context, userID, afterID int64, limit int) ([]websocket.Event, bool, error) {
+	return nil, true, nil
+}
+
+func TestCancelTask(t *testing.T) {
+	tests := []struct {
+		status string
+		userID int64
+		code   int
+		want   string
+		event  string
+	}{
+		{status: "pending", userID: 1, code: http.StatusOK, want: "cancelled", event: "task_cancelled"},
+		{status: "queued", userID: 1, code: http.StatusOK, want: "cancelled", event: "task_cancelled"},
+		{status: "blocked", userID: 1, code: http.StatusOK, want: "cancelled", event: "task_cancelled"},
+		{status: "processing", userID: 1, code: http.StatusAccepted, want: "processing", event: "task_cancel_requested"},
+		{status: "completed", userID: 1, code: http.StatusBadRequest, want: "completed"},
+		{status: "pending", userID: 2, code: http.StatusBadRequest, want: "pending"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s as user %d", tt.status, tt.userID), func(t *testing.T) {
+			store := database.NewMemoryStore()
+			events := &recordedEvents{}
+			hub := websocket.NewHub()
+			hub.Events = events
+			h := &TaskHandler{Tasks: store, Hub: hub}
+			r := newTestRouter()
+			r.DELETE("/api/tasks/:id", h.Cancel)
+
+			task := &models.Task{UserID: 1, Name: "a", Type: "email", Priority: "high", Status: tt.status, WorkflowID: 7}
+			if err := store.CreateTask(context.Background(), task); err != nil {
+				t.Fatal(err)
+			}
+
+			path := fmt.Sprintf("/api/tasks/%d", task.ID)
+			if code := serve(t, r, http.MethodDelete, path, tt.userID, "", nil, nil); code != tt.code {
+				t.Errorf("cancel returned %d, want %d", code, tt.code)
+			}
+			got, err := store.GetTask(context.Background(), task.ID, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.want {
+				t.Errorf("status = %s, want %s", got.Status, tt.want)
+			}
+			if got.CancelRequested != (tt.code == http.StatusAccepted) {
+				t.Errorf("cancel requested = %v", got.CancelRequested)
+			}
+
+			if tt.event == "" {
+				if len(events.events) != 0 {
+					t.Errorf("failed cancel broadcast %v", events.events)
+				}
+				return
+			}
+			if len(events.events) != 1 {
+				t.Fatalf("got %d events, want 1", len(events.events))
+			}
+			// Subscribers by workflow or task type must see the cancel.
+			e := events.events[0]
+			if e.Type != tt.event || e.TaskID != task.ID || e.WorkflowID != 7 || e.TaskType != "email" {
+				t.Errorf("got %s event for task %d, workflow %d, type %q",
+					e.Type, e.TaskID, e.WorkflowID, e.TaskType)
+			}
+		})
+	}
+}
+
+func TestTaskStats(t *testing.T) {
+	store := database.NewMemoryStore()
+	r := newTaskRouter(store)
+	for _, status := range []string{"pending", "pending", "completed", "failed", "timed_out"} {
+		task := &models.Task{UserID: 1, Name: "a", Type: "email", Priority: "high", Status: status}
+		if err := store.CreateTask(context.Background(), task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.CreateTask(context.Background(), &models.Task{UserID: 2, Status: "pending"})
+
+	var stats database.TaskStats
+	if code := serve(t, r, http.MethodGet, "/api/tasks/stats", 1, "", nil, &stats); code != http.StatusOK {
+		t.Fatalf("stats returned %d", code)
+	}
+	want := database.TaskStats{Total: 5, Pending: 2, Completed: 1, Failed: 1, TimedOut: 1}
+	if stats != want {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+}
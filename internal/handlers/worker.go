@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/database"
+	"taskqueue/internal/models"
+	"taskqueue/internal/websocket"
+	"taskqueue/pkg/logger"
+)
+
+// WorkerHandler provides the HTTP callbacks through which workers outside
+// the server register and report on the tasks they process. Tasks are
+// identified by the ID of the queue message that delivered them.
+type WorkerHandler struct {
+	DB  *pgxpool.Pool
+	Hub *websocket.Hub
+}
+
+// staleMessage answers a callback for a task that is no longer in the state
+// the callback expects. The worker should delete the message and move on.
+func staleMessage(c *gin.Context) {
+	c.JSON(http.StatusConflict, gin.H{"error": database.ErrStaleMessage.Error()})
+}
+
+// Register handles POST /api/worker/register when a worker starts, recording
+// what it is and which task types it handles.
+func (h *WorkerHandler) Register(c *gin.Context) {
+	var req struct {
+		WorkerID  string   `json:"worker_id" binding:"required,max=255"`
+		Language  string   `json:"language" binding:"max=50"`
+		Host      string   `json:"host" binding:"max=255"`
+		TaskTypes []string `json:"task_types"`
+		Version   string   `json:"version" binding:"max=100"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	worker := &models.Worker{
+		ID:        req.WorkerID,
+		Language:  req.Language,
+		Host:      req.Host,
+		TaskTypes: req.TaskTypes,
+		Version:   req.Version,
+	}
+	if err := database.RegisterWorker(c.Request.Context(), h.DB, worker); err != nil {
+		logger.Error("register worker:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register worker"})
+		return
+	}
+
+	c.JSON(http.StatusOK, worker)
+}
+
+// WorkerHeartbeat handles POST /api/worker/heartbeat, sent periodically by a
+// registered worker with the task it is running, if any. An unregistered
+// worker gets 404 and should register again.
+func (h *WorkerHandler) WorkerHeartbeat(c *gin.Context) {
+	var req struct {
+		WorkerID      string `json:"worker_id" binding:"required"`
+		CurrentTaskID int64  `json:"current_task_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := database.WorkerHeartbeat(c.Request.Context(), h.DB, req.WorkerID, req.CurrentTaskID)
+	if errors.Is(err, database.ErrWorkerNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error("worker heartbeat:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record heartbeat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Start handles POST /api/worker/tasks/:message_id/start when a worker
+// receives a message, moving its task to processing and opening an attempt.
+// task_id is the task named in the message body. 425 Too Early means the
+// message arrived before its task was marked queued; the worker should keep
+// the message and let it be redelivered shortly.
+func (h *WorkerHandler) Start(c *gin.Context) {
+	var req struct {
+		WorkerID string `json:"worker_id" binding:"required,max=255"`
+		TaskID   int64  `json:"task_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := database.UpdateTaskProgress(c.Request.Context(), h.DB, c.Param("message_id"), req.WorkerID, req.TaskID)
+	if errors.Is(err, database.ErrStaleMessage) {
+		staleMessage(c)
+		return
+	}
+	if errors.Is(err, database.ErrNotQueuedYet) {
+		c.JSON(http.StatusTooEarly, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error("start task:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start task"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "processing"})
+}
+
+// DeadLetter handles POST /api/worker/tasks/:message_id/dead-letter when a
+// worker receives a message it cannot parse. The message is recorded as a
+// malformed dead letter and the worker should then delete it.
+func (h *WorkerHandler) DeadLetter(c *gin.Context) {
+	var req struct {
+		Body     string `json:"body"`
+		Error    string `json:"error" binding:"required"`
+		Attempts int    `json:"attempts" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := database.InsertDeadLetter(c.Request.Context(), h.DB, &models.DeadLetter{
+		MessageID: c.Param("message_id"),
+		Body:      req.Body,
+		Reason:    "malformed",
+		Error:     req.Error,
+		Attempts:  req.Attempts,
+	})
+	if err != nil {
+		logger.Error("insert dead letter:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record dead letter"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "dead_lettered"})
+}
+
+// Heartbeat handles POST /api/worker/tasks/:message_id/heartbeat, sent
+// periodically while a task runs to show that its worker is still alive.
+// cancel_requested in the response tells the worker to stop the task and
+// report it with Cancel.
+func (h *WorkerHandler) Heartbeat(c *gin.Context) {
+	task, err := database.TaskHeartbeat(c.Request.Context(), h.DB, c.Param("message_id"))
+	if errors.Is(err, database.ErrStaleMessage) {
+		staleMessage(c)
+		return
+	}
+	if err != nil {
+		logger.Error("task heartbeat:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record heartbeat"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": task.Status, "cancel_requested": task.CancelRequested})
+}
+
+// Progress handles POST /api/worker/tasks/:message_id/progress to report how
+// far a task has got. It counts as a heartbeat, answered the same way, and
+// the progress is sent to the task's owner as a task_progress event.
+func (h *WorkerHandler) Progress(c *gin.Context) {
+	var req struct {
+		Progress *int   `json:"progress" binding:"required,min=0,max=100"`
+		Message  string `json:"message" binding:"max=1000"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	task, err := database.TaskHeartbeat(c.Request.Context(), h.DB, c.Param("message_id"))
+	if errors.Is(err, database.ErrStaleMessage) {
+		staleMessage(c)
+		return
+	}
+	if err != nil {
+		logger.Error("task progress:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record progress"})
+		return
+	}
+
+	if h.Hub != nil {
+		h.Hub.Broadcast(&websocket.Event{
+			UserID:     task.UserID,
+			Type:       "task_progress",
+			TaskID:     task.ID,
+			WorkflowID: task.WorkflowID,
+			TaskType:   task.Type,
+			Data: gin.H{
+				"task_id":  task.ID,
+				"attempt":  task.Attempt,
+				"progress": *req.Progress,
+				"message":  req.Message,
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": task.Status, "cancel_requested": task.CancelRequested})
+}
+
+// Complete handles POST /api/worker/tasks/:message_id/complete to record a
+// task's result.
+func (h *WorkerHandler) Complete(c *gin.Context) {
+	var req struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := database.CompleteTask(c.Request.Context(), h.DB, c.Param("message_id"), req.Result)
+	if errors.Is(err, database.ErrStaleMessage) {
+		staleMessage(c)
+		return
+	}
+	if err != nil {
+		logger.Error("complete task:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete task"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "completed"})
+}
+
+// Fail handles POST /api/worker/tasks/:message_id/fail to record a failed
+// attempt. The task is retried or failed according to its retry policy, and
+// the response carries the status it moved to.
+func (h *WorkerHandler) Fail(c *gin.Context) {
+	var req struct {
+		Error string `json:"error" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	status, err := database.FailTask(c.Request.Context(), h.DB, c.Param("message_id"), req.Error)
+	if errors.Is(err, database.ErrStaleMessage) {
+		staleMessage(c)
+		return
+	}
+	if err != nil {
+		logger.Error("fail task:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record failure"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": status})
+}
+
+// Timeout handles POST /api/worker/tasks/:message_id/timeout when a task has
+// run past its timeout. The task ends timed_out, without a retry.
+func (h *WorkerHandler) Timeout(c *gin.Context) {
+	var req struct {
+		Error string `json:"error"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Error == "" {
+		req.Error = "task timed out"
+	}
+
+	err := database.TimeoutTask(c.Request.Context(), h.DB, c.Param("message_id"), req.Error)
+	if errors.Is(err, database.ErrStaleMessage) {
+		staleMessage(c)
+		return
+	}
+	if err != nil {
+		logger.Error("time out task:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record timeout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "timed_out"})
+}
+
+// Cancel handles POST /api/worker/tasks/:message_id/cancel when a worker has
+// stopped a task whose owner asked to cancel it. The task ends cancelled.
+func (h *WorkerHandler) Cancel(c *gin.Context) {
+	err := database.CancelRunningTask(c.Request.Context(), h.DB, c.Param("message_id"))
+	if errors.Is(err, database.ErrStaleMessage) {
+		staleMessage(c)
+		return
+	}
+	if err != nil {
+		logger.Error("cancel running task:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record cancellation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "cancelled"})
+}
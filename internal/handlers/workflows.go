@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/database"
+	"taskqueue/internal/models"
+	"taskqueue/internal/outbox"
+	"taskqueue/internal/websocket"
+	"taskqueue/pkg/logger"
+)
+
+// maxWorkflowTasks limits the number of tasks in a single workflow.
+const maxWorkflowTasks = 100
+
+// WorkflowHandler provides HTTP handlers for workflows of dependent tasks.
+type WorkflowHandler struct {
+	DB    *pgxpool.Pool
+	Relay *outbox.Relay
+	Hub   *websocket.Hub
+
+	// TaskTimeouts are the default timeouts by task type, for tasks created
+	// without one.
+	TaskTimeouts map[string]time.Duration
+}
+
+// workflowTaskRequest is a task in a workflow. Key names the task within the
+// request so other tasks can list it in DependsOn.
+type workflowTaskRequest struct {
+	taskRequest
+	Key       string   `json:"key" binding:"required"`
+	DependsOn []string `json:"depends_on"`
+}
+
+// Create handles POST /api/workflows to create a workflow. Tasks without
+// dependencies are scheduled immediately; the rest wait until all of their
+// parents complete.
+func (h *WorkflowHandler) Create(c *gin.Context) {
+	var req struct {
+		Name  string                `json:"name" binding:"required"`
+		Tasks []workflowTaskRequest `json:"tasks" binding:"required,min=1,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Tasks) > maxWorkflowTasks {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("a workflow may have at most %d tasks", maxWorkflowTasks)})
+		return
+	}
+
+	parents, err := resolveDependencies(req.Tasks)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userIDInterface, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDInterface.(int64)
+
+	workflow := &models.Workflow{UserID: userID, Name: req.Name}
+	tasks := make([]*models.Task, len(req.Tasks))
+	for i := range req.Tasks {
+		tasks[i] = req.Tasks[i].task(userID)
+		applyTimeout(tasks[i], h.TaskTimeouts)
+	}
+
+	if err := database.CreateWorkflow(c.Request.Context(), h.DB, workflow, tasks, parents); err != nil {
+		logger.Error("create workflow:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create workflow"})
+		return
+	}
+	if h.Relay != nil {
+		h.Relay.Notify()
+	}
+
+	if h.Hub != nil {
+		h.Hub.Broadcast(&websocket.Event{
+			UserID:     userID,
+			Type:       "workflow_created",
+			WorkflowID: workflow.ID,
+			Data:       workflow,
+		})
+	}
+
+	c.JSON(http.StatusAccepted, struct {
+		*models.Workflow
+		Tasks []*models.Task
+	}{workflow, tasks})
+}
+
+// List handles GET /api/workflows to list the user's workflows.
+func (h *WorkflowHandler) List(c *gin.Context) {
+	userIDInterface, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDInterface.(int64)
+
+	limit, offset := 50, 0
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	workflows, err := database.ListWorkflows(c.Request.Context(), h.DB, userID, limit, offset)
+	if err != nil {
+		logger.Error("list workflows:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list workflows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, workflows)
+}
+
+// Get handles GET /api/workflows/:id to get a workflow with its tasks.
+func (h *WorkflowHandler) Get(c *gin.Context) {
+	userIDInterface, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDInterface.(int64)
+
+	workflowID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid workflow id"})
+		return
+	}
+
+	workflow, err := database.GetWorkflow(c.Request.Context(), h.DB, workflowID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "workflow not found"})
+		return
+	}
+	if err != nil {
+		logger.Error("get workflow:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get workflow"})
+		return
+	}
+
+	tasks, err := database.ListWorkflowTasks(c.Request.Context(), h.DB, workflowID)
+	if err != nil {
+		logger.Error("list workflow tasks:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get workflow"})
+		return
+	}
+
+	c.JSON(http.StatusOK, struct {
+		*models.Workflow
+		Tasks []models.Task
+	}{workflow, tasks})
+}
+
+// resolveDependencies maps each task's depends_on keys to indexes into tasks
+// and rejects duplicate keys, unknown keys and cycles.
+func resolveDependencies(tasks []workflowTaskRequest) ([][]int, error) {
+	index := make(map[string]int, len(tasks))
+	for i, t := range tasks {
+		if _, dup := index[t.Key]; dup {
+			return nil, fmt.Errorf("duplicate task key %q", t.Key)
+		}
+		index[t.Key] = i
+	}
+
+	parents := make([][]int, len(tasks))
+	children := make([][]int, len(tasks))
+	for i, t := range tasks {
+		seen := map[int]bool{}
+		for _, key := range t.DependsOn {
+			p, ok := index[key]
+			if !ok {
+				return nil, fmt.Errorf("task %q depends on unknown task %q", t.Key, key)
+			}
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			parents[i] = append(parents[i], p)
+			children[p] = append(children[p], i)
+		}
+	}
+
+	// Kahn's algorithm: every task is visited only if the graph is acyclic.
+	pending := make([]int, len(tasks))
+	ready := []int{}
+	for i := range tasks {
+		pending[i] = len(parents[i])
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		n := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		visited++
+		for _, child := range children[n] {
+			pending[child]--
+			if pending[child] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+	if visited != len(tasks) {
+		return nil, errors.New("workflow dependencies contain a cycle")
+	}
+	return parents, nil
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveDependencies(t *testing.T) {
+	// steps builds tasks from "key:parent,parent" specs.
+	steps := func(specs ...string) []workflowTaskRequest {
+		tasks := make([]workflowTaskRequest, len(specs))
+		for i, spec := range specs {
+			key, deps, _ := strings.Cut(spec, ":")
+			tasks[i].Key = key
+			if deps != "" {
+				tasks[i].DependsOn = strings.Split(deps, ",")
+			}
+		}
+		return tasks
+	}
+	tests := []struct {
+		name  string
+		tasks []workflowTaskRequest
+		want  [][]int
+		err   string
+	}{
+		{
+			name:  "independent",
+			tasks: steps("a", "b"),
+			want:  [][]int{nil, nil},
+		},
+		{
+			name:  "diamond",
+			tasks: steps("fetch", "resize:fetch", "thumb:fetch", "publish:resize,thumb"),
+			want:  [][]int{nil, {0}, {0}, {1, 2}},
+		},
+		{
+			name:  "parents listed after children",
+			tasks: steps("publish:resize,thumb", "resize:fetch", "thumb:fetch", "fetch"),
+			want:  [][]int{{1, 2}, {3}, {3}, nil},
+		},
+		{
+			name:  "repeated dependency",
+			tasks: steps("a", "b:a,a"),
+			want:  [][]int{nil, {0}},
+		},
+		{
+			name:  "cycle",
+			tasks: steps("a:c", "b:a", "c:b"),
+			err:   "cycle",
+		},
+		{
+			name:  "cycle below a root",
+			tasks: steps("root", "a:root,b", "b:a"),
+			err:   "cycle",
+		},
+		{
+			name:  "self dependency",
+			tasks: steps("a:a"),
+			err:   "cycle",
+		},
+		{
+			name:  "unknown key",
+			tasks: steps("a", "b:missing"),
+			err:   `unknown task "missing"`,
+		},
+		{
+			name:  "duplicate key",
+			tasks: steps("a", "a"),
+			err:   `duplicate task key "a"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveDependencies(tt.tasks)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminRequired allows only the given user IDs through. It must run after
+// AuthRequired or APIAuthRequired has set user_id.
+func AdminRequired(adminIDs []int64) gin.HandlerFunc {
+	admins := make(map[int64]bool, len(adminIDs))
+	for _, id := range adminIDs {
+		admins[id] = true
+	}
+
+	return func(c *gin.Context) {
+		userID, ok := c.Get("user_id")
+		if !ok || !admins[userID.(int64)] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -2,6 +2,9 @@ package models
 
 import "time"
 
+// maxRetryDelay caps the delay computed by Task.RetryDelay.
+const maxRetryDelay = time.Hour
+
 // User represents an authenticated user.
 type User struct {
 	ID        int64     `db:"id"`
@@ -30,4 +33,147 @@ type Task struct {
 	CompletedAt time.Time `db:"completed_at"`
 	CreatedAt   time.Time `db:"created_at"`
 	UpdatedAt   time.Time `db:"updated_at"`
+
+	// Retry policy
+	Attempt        int    `db:"attempt"`
+	MaxAttempts    int    `db:"max_attempts"`
+	Backoff        string `db:"backoff"`
+	BackoffSeconds int    `db:"backoff_seconds"`
+
+	// TimeoutSeconds limits how long one attempt may run; zero means no limit
+	TimeoutSeconds int `db:"timeout_seconds"`
+
+	// CancelRequested is set when the owner cancels the task while it is
+	// processing; its worker should stop and end it cancelled
+	CancelRequested bool `db:"cancel_requested"`
+
+	// Workflow membership; DependsOn is only loaded with the workflow
+	WorkflowID int64   `db:"workflow_id"`
+	DependsOn  []int64 `db:"-"`
+}
+
+// RetryDelay returns how long to wait before retrying after the current
+// attempt failed, according to the task's backoff policy.
+func (t *Task) RetryDelay() time.Duration {
+	base := time.Duration(t.BackoffSeconds) * time.Second
+	n := t.Attempt
+	if n < 1 {
+		n = 1
+	}
+
+	var d time.Duration
+	switch t.Backoff {
+	case "fixed":
+		d = base
+	case "linear":
+		d = base * time.Duration(n)
+	default: // exponential
+		if n > 20 {
+			n = 20
+		}
+		d = base << (n - 1)
+	}
+	if d > maxRetryDelay || d < 0 {
+		d = maxRetryDelay
+	}
+	return d
+}
+
+// TaskAttempt records one processing attempt of a task.
+type TaskAttempt struct {
+	ID         int64     `db:"id"`
+	TaskID     int64     `db:"task_id"`
+	Attempt    int       `db:"attempt"`
+	Status     string    `db:"status"`
+	WorkerID   string    `db:"worker_id"`
+	MessageID  string    `db:"message_id"`
+	Error      string    `db:"error_message"`
+	StartedAt  time.Time `db:"started_at"`
+	FinishedAt time.Time `db:"finished_at"`
+}
+
+// DeadLetter is a message that exhausted its attempts or could not be parsed.
+type DeadLetter struct {
+	ID        int64     `db:"id"`
+	TaskID    int64     `db:"task_id"`
+	TaskName  string    `db:"task_name"`
+	TaskType  string    `db:"task_type"`
+	MessageID string    `db:"message_id"`
+	Body      string    `db:"body"`
+	Reason    string    `db:"reason"`
+	Error     string    `db:"error_message"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Workflow is a group of tasks connected by dependency edges. Status and the
+// counts are aggregated from its tasks; Failed includes timed out tasks.
+type Workflow struct {
+	ID        int64     `db:"id"`
+	UserID    int64     `db:"user_id"`
+	Name      string    `db:"name"`
+	Status    string    `db:"-"`
+	CreatedAt time.Time `db:"created_at"`
+
+	Total     int `db:"total"`
+	Active    int `db:"active"`
+	Completed int `db:"completed"`
+	Failed    int `db:"failed"`
+	Skipped   int `db:"skipped"`
+	Cancelled int `db:"cancelled"`
+}
+
+// Worker is a worker process known from its registration and heartbeats.
+// Health is healthy, stale or dead, from the time since the last heartbeat.
+type Worker struct {
+	ID              string    `db:"id"`
+	Language        string    `db:"language"`
+	Host            string    `db:"host"`
+	TaskTypes       []string  `db:"task_types"`
+	Version         string    `db:"version"`
+	CurrentTaskID   int64     `db:"current_task_id"`
+	StartedAt       time.Time `db:"started_at"`
+	LastHeartbeatAt time.Time `db:"last_heartbeat_at"`
+	Health          string    `db:"health"`
+}
+
+// Schedule creates a task once at RunAt, or repeatedly from a cron
+// expression. NextRunAt is zero once a one-off schedule has run.
+type Schedule struct {
+	ID       int64  `db:"id"`
+	UserID   int64  `db:"user_id"`
+	Name     string `db:"name"`
+	Type     string `db:"type"`
+	Priority string `db:"priority"`
+	Payload  []byte `db:"payload"`
+
+	MaxAttempts    int    `db:"max_attempts"`
+	Backoff        string `db:"backoff"`
+	BackoffSeconds int    `db:"backoff_seconds"`
+	TimeoutSeconds int    `db:"timeout_seconds"`
+
+	Cron       string    `db:"cron_expr"`
+	RunAt      time.Time `db:"run_at"`
+	NextRunAt  time.Time `db:"next_run_at"`
+	LastRunAt  time.Time `db:"last_run_at"`
+	LastTaskID int64     `db:"last_task_id"`
+	Enabled    bool      `db:"enabled"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+// NewTask returns the pending task a schedule creates when it fires.
+func (s *Schedule) NewTask() *Task {
+	return &Task{
+		UserID:         s.UserID,
+		Name:           s.Name,
+		Type:           s.Type,
+		Priority:       s.Priority,
+		Status:         "pending",
+		Payload:        s.Payload,
+		MaxAttempts:    s.MaxAttempts,
+		Backoff:        s.Backoff,
+		BackoffSeconds: s.BackoffSeconds,
+		TimeoutSeconds: s.TimeoutSeconds,
+	}
 }
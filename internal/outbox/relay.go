@@ -0,0 +1,117 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/database"
+	"taskqueue/internal/queue"
+	"taskqueue/pkg/logger"
+)
+
+// txEnqueuer is implemented by brokers that can enqueue inside a database
+// transaction, such as queue.Postgres.
+type txEnqueuer interface {
+	EnqueueTx(ctx context.Context, tx pgx.Tx, body string, priority string, delay time.Duration) (string, error)
+}
+
+// Relay publishes task_outbox rows to the queue broker and marks their tasks
+// queued. Several relays may run against the same database; rows are claimed
+// with SKIP LOCKED so each is published once.
+type Relay struct {
+	DB       *pgxpool.Pool
+	Broker   queue.Broker
+	Interval time.Duration
+
+	// Published, if set, is called after a task has been queued.
+	Published func(e *database.OutboxEntry, messageID string)
+
+	wake chan struct{}
+}
+
+// NewRelay creates a relay that polls the outbox every interval.
+func NewRelay(db *pgxpool.Pool, broker queue.Broker, interval time.Duration) *Relay {
+	return &Relay{
+		DB:       db,
+		Broker:   broker,
+		Interval: interval,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes the relay without waiting for the next poll. It never blocks.
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes due outbox rows until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Flush(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("outbox relay:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// Flush publishes every outbox row that is currently due. It stops at the
+// first error; the failed row is retried after a backoff.
+func (r *Relay) Flush(ctx context.Context) error {
+	for {
+		var msgID string
+		var entry *database.OutboxEntry
+		found, err := database.PublishNextOutbox(ctx, r.DB, func(ctx context.Context, tx pgx.Tx, e *database.OutboxEntry) (string, error) {
+			id, err := r.publish(ctx, tx, e)
+			msgID, entry = id, e
+			return id, err
+		})
+		if err != nil || !found {
+			return err
+		}
+		if entry != nil && msgID != "" && r.Published != nil {
+			r.Published(entry, msgID)
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, tx pgx.Tx, e *database.OutboxEntry) (string, error) {
+	body, err := (&queue.TaskMessage{
+		TaskID:         e.TaskID,
+		Type:           e.Type,
+		Payload:        e.Payload,
+		TimeoutSeconds: e.TimeoutSeconds,
+	}).Encode()
+	if err != nil {
+		return "", err
+	}
+
+	if txq, ok := r.Broker.(txEnqueuer); ok {
+		// Use a savepoint so a failed insert leaves the outer transaction
+		// usable for recording the failure.
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			return "", err
+		}
+		id, err := txq.EnqueueTx(ctx, sp, body, e.Priority, 0)
+		if err != nil {
+			sp.Rollback(ctx)
+			return "", err
+		}
+		return id, sp.Commit(ctx)
+	}
+	return r.Broker.EnqueueWithPriority(ctx, body, e.Priority)
+}
@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/config"
+)
+
+// Broker is implemented by every queue backend. Message bodies are opaque
+// strings; the returned message ID is what tasks.message_id is matched on.
+type Broker interface {
+	// Enqueue sends a message with default priority.
+	Enqueue(ctx context.Context, body string) (string, error)
+	// EnqueueWithPriority sends a message with the given priority (low, medium, high).
+	EnqueueWithPriority(ctx context.Context, body string, priority string) (string, error)
+	// EnqueueWithDelay sends a message that becomes visible after delay.
+	EnqueueWithDelay(ctx context.Context, body string, priority string, delay time.Duration) (string, error)
+	// ReceiveMessages long-polls for up to maxMessages messages.
+	ReceiveMessages(ctx context.Context, maxMessages int32) ([]*Message, error)
+	// DeleteMessage acknowledges a received message.
+	DeleteMessage(ctx context.Context, receiptHandle string) error
+	// ExtendVisibility hides a received message from other consumers for timeout.
+	ExtendVisibility(ctx context.Context, receiptHandle string, timeout time.Duration) error
+	// GetQueueAttributes returns queue metrics keyed by the SQS attribute names.
+	GetQueueAttributes(ctx context.Context) (map[string]string, error)
+}
+
+// Message represents a received queue message
+type Message struct {
+	ID           string
+	Body         string
+	Priority     string
+	Receipt      string
+	ReceiveCount int
+
+	// Err is set when the broker received a message it could not parse.
+	// The other fields hold whatever could be read.
+	Err error
+}
+
+// Queue attribute names reported by GetQueueAttributes.
+const (
+	AttrVisible  = "ApproximateNumberOfMessages"
+	AttrInFlight = "ApproximateNumberOfMessagesNotVisible"
+	AttrDelayed  = "ApproximateNumberOfMessagesDelayed"
+)
+
+// Open creates the broker selected by cfg.QueueBackend. db is only used by
+// the postgres backend. The memory backend is only visible inside this process.
+func Open(ctx context.Context, cfg *config.Config, db *pgxpool.Pool) (Broker, error) {
+	switch cfg.QueueBackend {
+	case "postgres":
+		return NewPostgres(db, cfg.QueueName, cfg.VisibilityTimeout), nil
+	case "memory":
+		return NewMemory(cfg.VisibilityTimeout), nil
+	case "sqs":
+		if cfg.SQSQueueURL == "" {
+			return nil, fmt.Errorf("AWS_SQS_QUEUE_URL is required for the sqs backend")
+		}
+		return New(ctx, cfg.AWSRegion, cfg.SQSQueueURL)
+	default:
+		return nil, fmt.Errorf("unknown queue backend %q", cfg.QueueBackend)
+	}
+}
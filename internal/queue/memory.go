@@ -0,0 +1,232 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryWaitTime mirrors the SQS long-poll duration.
+const memoryWaitTime = 20 * time.Second
+
+// Memory is an in-process Broker with SQS semantics: random message IDs, a
+// fresh receipt handle per receive, visibility timeouts, delayed delivery and
+// long-polling receives. Messages are lost when the process exits.
+type Memory struct {
+	visibility time.Duration
+
+	mu       sync.Mutex
+	seq      int64
+	messages map[string]*memoryMessage // by message ID
+	receipts map[string]string         // receipt handle -> message ID
+	wake     chan struct{}             // closed and replaced on every enqueue
+}
+
+type memoryMessage struct {
+	Message
+	seq       int64
+	visibleAt time.Time
+}
+
+var _ Broker = (*Memory)(nil)
+
+// NewMemory creates an empty in-memory broker. Received messages stay
+// invisible to other consumers for visibility.
+func NewMemory(visibility time.Duration) *Memory {
+	return &Memory{
+		visibility: visibility,
+		messages:   make(map[string]*memoryMessage),
+		receipts:   make(map[string]string),
+		wake:       make(chan struct{}),
+	}
+}
+
+// memoryPriorityDelay emulates priority the same way the SQS client does for
+// standard queues: lower priorities are delayed.
+func memoryPriorityDelay(priority string) time.Duration {
+	switch priority {
+	case "low":
+		return 30 * time.Second
+	case "medium":
+		return 10 * time.Second
+	default:
+		return 0
+	}
+}
+
+// Enqueue adds a message that is immediately visible.
+func (m *Memory) Enqueue(ctx context.Context, body string) (string, error) {
+	return m.add(body, "", 0), nil
+}
+
+// EnqueueWithPriority adds a message, delaying low and medium priorities.
+func (m *Memory) EnqueueWithPriority(ctx context.Context, body string, priority string) (string, error) {
+	return m.add(body, priority, memoryPriorityDelay(priority)), nil
+}
+
+// EnqueueWithDelay adds a message that becomes visible after delay.
+// Delays are capped at 15 minutes like SQS DelaySeconds.
+func (m *Memory) EnqueueWithDelay(ctx context.Context, body string, priority string, delay time.Duration) (string, error) {
+	if delay > maxSQSDelay {
+		delay = maxSQSDelay
+	}
+	return m.add(body, priority, delay), nil
+}
+
+func (m *Memory) add(body, priority string, delay time.Duration) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.seq++
+	msg := &memoryMessage{
+		Message: Message{
+			ID:       newMemoryID(),
+			Body:     body,
+			Priority: priority,
+		},
+		seq:       m.seq,
+		visibleAt: now.Add(delay),
+	}
+	m.messages[msg.ID] = msg
+
+	close(m.wake)
+	m.wake = make(chan struct{})
+	return msg.ID
+}
+
+// ReceiveMessages returns up to maxMessages visible messages, waiting up to
+// 20 seconds for one to become visible.
+func (m *Memory) ReceiveMessages(ctx context.Context, maxMessages int32) ([]*Message, error) {
+	deadline := time.NewTimer(memoryWaitTime)
+	defer deadline.Stop()
+
+	for {
+		msgs, next, wake := m.claim(int(maxMessages))
+		if len(msgs) > 0 {
+			return msgs, nil
+		}
+
+		var retry *time.Timer
+		var retryC <-chan time.Time
+		if !next.IsZero() {
+			retry = time.NewTimer(time.Until(next))
+			retryC = retry.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return nil, nil
+		case <-wake:
+		case <-retryC:
+		}
+		if retry != nil {
+			retry.Stop()
+		}
+	}
+}
+
+// claim marks up to max visible messages as received. When none are visible
+// it returns the time the next message becomes visible and the channel that
+// is closed on the next enqueue.
+func (m *Memory) claim(max int) ([]*Message, time.Time, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var ready []*memoryMessage
+	var next time.Time
+	for _, msg := range m.messages {
+		if !msg.visibleAt.After(now) {
+			ready = append(ready, msg)
+		} else if next.IsZero() || msg.visibleAt.Before(next) {
+			next = msg.visibleAt
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].seq < ready[j].seq })
+	if len(ready) > max {
+		ready = ready[:max]
+	}
+
+	msgs := make([]*Message, 0, len(ready))
+	for _, msg := range ready {
+		if msg.Receipt != "" {
+			delete(m.receipts, msg.Receipt)
+		}
+		msg.Receipt = newMemoryID()
+		msg.ReceiveCount++
+		msg.visibleAt = now.Add(m.visibility)
+		m.receipts[msg.Receipt] = msg.ID
+
+		copied := msg.Message
+		msgs = append(msgs, &copied)
+	}
+	return msgs, next, m.wake
+}
+
+// DeleteMessage removes a received message. Only the latest receipt handle is valid.
+func (m *Memory) DeleteMessage(ctx context.Context, receiptHandle string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, ok := m.receipts[receiptHandle]
+	if !ok {
+		return fmt.Errorf("receipt handle not found")
+	}
+	delete(m.receipts, receiptHandle)
+	delete(m.messages, id)
+	return nil
+}
+
+// ExtendVisibility keeps a received message hidden for timeout from now.
+func (m *Memory) ExtendVisibility(ctx context.Context, receiptHandle string, timeout time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, ok := m.receipts[receiptHandle]
+	if !ok {
+		return fmt.Errorf("receipt handle not found")
+	}
+	m.messages[id].visibleAt = time.Now().Add(timeout)
+	if timeout == 0 {
+		close(m.wake)
+		m.wake = make(chan struct{})
+	}
+	return nil
+}
+
+// GetQueueAttributes reports visible, in-flight and delayed message counts.
+func (m *Memory) GetQueueAttributes(ctx context.Context) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var visible, inFlight, delayed int
+	for _, msg := range m.messages {
+		switch {
+		case !msg.visibleAt.After(now):
+			visible++
+		case msg.ReceiveCount > 0:
+			inFlight++
+		default:
+			delayed++
+		}
+	}
+	return map[string]string{
+		AttrVisible:  fmt.Sprint(visible),
+		AttrInFlight: fmt.Sprint(inFlight),
+		AttrDelayed:  fmt.Sprint(delayed),
+	}, nil
+}
+
+func newMemoryID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,268 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// receiveNow receives without long-polling: it gives up as soon as no
+// message is visible.
+func receiveNow(t *testing.T, m *Memory, max int32) []*Message {
+	t.Helper()
+	msgs, _, _ := m.claim(int(max))
+	return msgs
+}
+
+func bodies(msgs []*Message) []string {
+	list := make([]string, len(msgs))
+	for i, msg := range msgs {
+		list[i] = msg.Body
+	}
+	return list
+}
+
+func TestMemoryReceive(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		enqueue func(m *Memory)
+		max     int32
+		want    []string
+	}{
+		{
+			name:    "empty",
+			enqueue: func(m *Memory) {},
+			max:     10,
+			want:    []string{},
+		},
+		{
+			name: "in enqueue order",
+			enqueue: func(m *Memory) {
+				m.Enqueue(ctx, "a")
+				m.Enqueue(ctx, "b")
+				m.Enqueue(ctx, "c")
+			},
+			max:  10,
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "at most max",
+			enqueue: func(m *Memory) {
+				m.Enqueue(ctx, "a")
+				m.Enqueue(ctx, "b")
+				m.Enqueue(ctx, "c")
+			},
+			max:  2,
+			want: []string{"a", "b"},
+		},
+		{
+			name: "high priority only",
+			enqueue: func(m *Memory) {
+				m.EnqueueWithPriority(ctx, "low", "low")
+				m.EnqueueWithPriority(ctx, "medium", "medium")
+				m.EnqueueWithPriority(ctx, "high", "high")
+			},
+			max:  10,
+			want: []string{"high"},
+		},
+		{
+			name: "delayed",
+			enqueue: func(m *Memory) {
+				m.EnqueueWithDelay(ctx, "later", "high", time.Minute)
+				m.Enqueue(ctx, "now")
+			},
+			max:  10,
+			want: []string{"now"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory(time.Minute)
+			tt.enqueue(m)
+			got := bodies(receiveNow(t, m, tt.max))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryAck(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(10 * time.Millisecond)
+	id, _ := m.Enqueue(ctx, "a")
+
+	msgs := receiveNow(t, m, 1)
+	if len(msgs) != 1 || msgs[0].ID != id {
+		t.Fatalf("got %v, want message %s", bodies(msgs), id)
+	}
+	if err := m.DeleteMessage(ctx, msgs[0].Receipt); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if err := m.DeleteMessage(ctx, msgs[0].Receipt); err == nil {
+		t.Error("second DeleteMessage succeeded")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if got := receiveNow(t, m, 1); len(got) != 0 {
+		t.Errorf("acked message redelivered: %v", bodies(got))
+	}
+}
+
+func TestMemoryVisibility(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// after runs once the message has been received with receipt.
+		after       func(m *Memory, receipt string)
+		wait        time.Duration
+		redelivered bool
+	}{
+		{
+			name:        "hidden until expiry",
+			after:       func(m *Memory, receipt string) {},
+			wait:        0,
+			redelivered: false,
+		},
+		{
+			name:        "redelivered after expiry",
+			after:       func(m *Memory, receipt string) {},
+			wait:        100 * time.Millisecond,
+			redelivered: true,
+		},
+		{
+			name: "extended",
+			after: func(m *Memory, receipt string) {
+				m.ExtendVisibility(ctx, receipt, time.Minute)
+			},
+			wait:        100 * time.Millisecond,
+			redelivered: false,
+		},
+		{
+			name: "released",
+			after: func(m *Memory, receipt string) {
+				m.ExtendVisibility(ctx, receipt, 0)
+			},
+			wait:        0,
+			redelivered: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory(50 * time.Millisecond)
+			m.Enqueue(ctx, "a")
+			first := receiveNow(t, m, 1)
+			if len(first) != 1 {
+				t.Fatal("message not received")
+			}
+			tt.after(m, first[0].Receipt)
+			time.Sleep(tt.wait)
+
+			again := receiveNow(t, m, 1)
+			if got := len(again) == 1; got != tt.redelivered {
+				t.Fatalf("redelivered = %v, want %v", got, tt.redelivered)
+			}
+			if !tt.redelivered {
+				return
+			}
+			if again[0].ReceiveCount != 2 {
+				t.Errorf("ReceiveCount = %d, want 2", again[0].ReceiveCount)
+			}
+			if again[0].Receipt == first[0].Receipt {
+				t.Error("redelivery kept the old receipt handle")
+			}
+			// Only the latest receipt handle is valid.
+			if err := m.DeleteMessage(ctx, first[0].Receipt); err == nil {
+				t.Error("DeleteMessage with a stale receipt succeeded")
+			}
+			if err := m.ExtendVisibility(ctx, first[0].Receipt, time.Minute); err == nil {
+				t.Error("ExtendVisibility with a stale receipt succeeded")
+			}
+			if err := m.DeleteMessage(ctx, again[0].Receipt); err != nil {
+				t.Errorf("DeleteMessage: %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryLongPollWakesOnEnqueue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m := NewMemory(time.Minute)
+
+	got := make(chan []*Message)
+	go func() {
+		msgs, err := m.ReceiveMessages(ctx, 1)
+		if err != nil {
+			t.Errorf("ReceiveMessages: %v", err)
+		}
+		got <- msgs
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	m.Enqueue(ctx, "a")
+	if msgs := <-got; len(msgs) != 1 || msgs[0].Body != "a" {
+		t.Errorf("got %v, want [a]", bodies(msgs))
+	}
+}
+
+func TestMemoryLongPollWaitsForDelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m := NewMemory(time.Minute)
+	m.EnqueueWithDelay(ctx, "a", "high", 20*time.Millisecond)
+
+	start := time.Now()
+	msgs, err := m.ReceiveMessages(ctx, 1)
+	if err != nil {
+		t.Fatalf("ReceiveMessages: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Body != "a" {
+		t.Fatalf("got %v, want [a]", bodies(msgs))
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("received after %v, before the delay", elapsed)
+	}
+}
+
+func TestMemoryLongPollCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewMemory(time.Minute)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := m.ReceiveMessages(ctx, 1); err != context.Canceled {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestMemoryQueueAttributes(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(time.Minute)
+	m.Enqueue(ctx, "in flight")
+	m.Enqueue(ctx, "visible")
+	m.Enqueue(ctx, "visible")
+	m.EnqueueWithDelay(ctx, "delayed", "high", time.Minute)
+	receiveNow(t, m, 1)
+
+	attrs, err := m.GetQueueAttributes(ctx)
+	if err != nil {
+		t.Fatalf("GetQueueAttributes: %v", err)
+	}
+	want := map[string]string{AttrVisible: "2", AttrInFlight: "1", AttrDelayed: "1"}
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("%s = %s, want %s", k, attrs[k], v)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package queue
+
+import "encoding/json"
+
+// TaskMessage is the body of every task message placed on the queue.
+type TaskMessage struct {
+	TaskID  int64           `json:"task_id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+
+	// TimeoutSeconds is how long the task may run, zero for no limit.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+}
+
+// Encode returns the JSON message body.
+func (m *TaskMessage) Encode() (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// DecodeTaskMessage parses a message body produced by TaskMessage.Encode.
+func DecodeTaskMessage(body string) (*TaskMessage, error) {
+	var m TaskMessage
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
@@ -0,0 +1,188 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// pgWaitTime mirrors the SQS long-poll duration.
+	pgWaitTime = 20 * time.Second
+	// pgPollInterval is how often an empty queue is re-checked while long-polling.
+	pgPollInterval = 500 * time.Millisecond
+)
+
+// Postgres is a Broker that stores messages in the queue_messages table and
+// claims them with SELECT ... FOR UPDATE SKIP LOCKED.
+type Postgres struct {
+	db         *pgxpool.Pool
+	queue      string
+	visibility time.Duration
+}
+
+var _ Broker = (*Postgres)(nil)
+
+// querier is satisfied by both *pgxpool.Pool and pgx.Tx.
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// NewPostgres creates a Postgres broker for the named queue. Received messages
+// stay invisible to other consumers for visibility.
+func NewPostgres(db *pgxpool.Pool, queue string, visibility time.Duration) *Postgres {
+	return &Postgres{
+		db:         db,
+		queue:      queue,
+		visibility: visibility,
+	}
+}
+
+// priorityRank orders messages within the queue; higher ranks are received first.
+func priorityRank(priority string) int {
+	switch priority {
+	case "high":
+		return 2
+	case "low":
+		return 0
+	default:
+		return 1
+	}
+}
+
+// Enqueue inserts a message with medium priority.
+func (p *Postgres) Enqueue(ctx context.Context, body string) (string, error) {
+	return p.insert(ctx, p.db, body, "medium", 0)
+}
+
+// EnqueueWithPriority inserts a message that is received before lower priorities.
+func (p *Postgres) EnqueueWithPriority(ctx context.Context, body string, priority string) (string, error) {
+	return p.insert(ctx, p.db, body, priority, 0)
+}
+
+// EnqueueWithDelay inserts a message that becomes visible after delay.
+// Unlike SQS there is no upper bound on the delay.
+func (p *Postgres) EnqueueWithDelay(ctx context.Context, body string, priority string, delay time.Duration) (string, error) {
+	return p.insert(ctx, p.db, body, priority, delay)
+}
+
+// EnqueueTx inserts a message inside tx, so it is only published if tx commits.
+func (p *Postgres) EnqueueTx(ctx context.Context, tx pgx.Tx, body string, priority string, delay time.Duration) (string, error) {
+	return p.insert(ctx, tx, body, priority, delay)
+}
+
+func (p *Postgres) insert(ctx context.Context, q querier, body, priority string, delay time.Duration) (string, error) {
+	var id string
+	err := q.QueryRow(ctx, `
+		INSERT INTO queue_messages (queue, body, priority, priority_rank, visible_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + make_interval(secs => $5))
+		RETURNING id::text`,
+		p.queue, body, priority, priorityRank(priority), delay.Seconds(),
+	).Scan(&id)
+	return id, err
+}
+
+// ReceiveMessages claims up to maxMessages visible messages, waiting up to
+// 20 seconds for one to arrive.
+func (p *Postgres) ReceiveMessages(ctx context.Context, maxMessages int32) ([]*Message, error) {
+	deadline := time.Now().Add(pgWaitTime)
+	for {
+		msgs, err := p.claim(ctx, maxMessages)
+		if err != nil || len(msgs) > 0 || time.Now().After(deadline) {
+			return msgs, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pgPollInterval):
+		}
+	}
+}
+
+func (p *Postgres) claim(ctx context.Context, maxMessages int32) ([]*Message, error) {
+	rows, err := p.db.Query(ctx, `
+		WITH next AS (
+			SELECT id FROM queue_messages
+			WHERE queue = $1 AND visible_at <= CURRENT_TIMESTAMP
+			ORDER BY priority_rank DESC, visible_at, created_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		), claimed AS (
+			UPDATE queue_messages m
+			SET receipt_handle = gen_random_uuid(),
+			    receive_count = m.receive_count + 1,
+			    visible_at = CURRENT_TIMESTAMP + make_interval(secs => $3)
+			FROM next WHERE m.id = next.id
+			RETURNING m.id, m.body, m.priority, m.priority_rank, m.receipt_handle,
+			          m.receive_count, m.created_at
+		)
+		SELECT id::text, body, priority, receipt_handle::text, receive_count
+		FROM claimed ORDER BY priority_rank DESC, created_at`,
+		p.queue, maxMessages, p.visibility.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []*Message
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.Body, &m.Priority, &m.Receipt, &m.ReceiveCount); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, &m)
+	}
+	return msgs, rows.Err()
+}
+
+// DeleteMessage removes a received message.
+func (p *Postgres) DeleteMessage(ctx context.Context, receiptHandle string) error {
+	tag, err := p.db.Exec(ctx, `
+		DELETE FROM queue_messages WHERE queue = $1 AND receipt_handle = $2::uuid`,
+		p.queue, receiptHandle)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("receipt handle not found")
+	}
+	return nil
+}
+
+// ExtendVisibility keeps a received message hidden for timeout from now.
+func (p *Postgres) ExtendVisibility(ctx context.Context, receiptHandle string, timeout time.Duration) error {
+	tag, err := p.db.Exec(ctx, `
+		UPDATE queue_messages
+		SET visible_at = CURRENT_TIMESTAMP + make_interval(secs => $3)
+		WHERE queue = $1 AND receipt_handle = $2::uuid`,
+		p.queue, receiptHandle, timeout.Seconds())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("receipt handle not found")
+	}
+	return nil
+}
+
+// GetQueueAttributes reports visible, in-flight and delayed message counts.
+func (p *Postgres) GetQueueAttributes(ctx context.Context) (map[string]string, error) {
+	var visible, inFlight, delayed int64
+	err := p.db.QueryRow(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE visible_at <= CURRENT_TIMESTAMP),
+			COUNT(*) FILTER (WHERE visible_at > CURRENT_TIMESTAMP AND receive_count > 0),
+			COUNT(*) FILTER (WHERE visible_at > CURRENT_TIMESTAMP AND receive_count = 0)
+		FROM queue_messages WHERE queue = $1`, p.queue).Scan(&visible, &inFlight, &delayed)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		AttrVisible:  fmt.Sprint(visible),
+		AttrInFlight: fmt.Sprint(inFlight),
+		AttrDelayed:  fmt.Sprint(delayed),
+	}, nil
+}
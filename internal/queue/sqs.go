@@ -3,6 +3,8 @@ package queue
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -10,12 +12,17 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 )
 
-// Client wraps AWS SQS.
+// maxSQSDelay is the largest DelaySeconds SQS accepts.
+const maxSQSDelay = 15 * time.Minute
+
+// Client wraps AWS SQS. It implements Broker.
 type Client struct {
 	svc      *sqs.Client
 	queueURL string
 }
 
+var _ Broker = (*Client)(nil)
+
 // New creates a new SQS client using default credentials.
 func New(ctx context.Context, region, queueURL string) (*Client, error) {
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
@@ -71,18 +78,58 @@ func (c *Client) EnqueueWithPriority(ctx context.Context, body string, priority
 	return aws.ToString(out.MessageId), nil
 }
 
+// EnqueueWithDelay sends a message to SQS that becomes visible after delay.
+// SQS caps DelaySeconds at 15 minutes; longer delays are clamped.
+func (c *Client) EnqueueWithDelay(ctx context.Context, body string, priority string, delay time.Duration) (string, error) {
+	if delay > maxSQSDelay {
+		delay = maxSQSDelay
+	}
+	out, err := c.svc.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:     aws.String(c.queueURL),
+		MessageBody:  aws.String(body),
+		DelaySeconds: int32(delay / time.Second),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"Priority": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(priority),
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.MessageId), nil
+}
+
 // ReceiveMessages polls for messages from SQS.
-func (c *Client) ReceiveMessages(ctx context.Context, maxMessages int32) ([]types.Message, error) {
+// Messages that cannot be parsed are returned with Err set so the consumer
+// can dead-letter them.
+func (c *Client) ReceiveMessages(ctx context.Context, maxMessages int32) ([]*Message, error) {
 	out, err := c.svc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
 		QueueUrl:              aws.String(c.queueURL),
 		MaxNumberOfMessages:   maxMessages,
 		WaitTimeSeconds:       20, // Long polling
 		MessageAttributeNames: []string{"All"},
+		AttributeNames:        []types.QueueAttributeName{"ApproximateReceiveCount"},
 	})
 	if err != nil {
 		return nil, err
 	}
-	return out.Messages, nil
+
+	msgs := make([]*Message, 0, len(out.Messages))
+	for _, raw := range out.Messages {
+		m, err := ParseMessage(raw)
+		if err != nil {
+			m = &Message{
+				ID:      aws.ToString(raw.MessageId),
+				Body:    aws.ToString(raw.Body),
+				Receipt: aws.ToString(raw.ReceiptHandle),
+				Err:     err,
+			}
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, nil
 }
 
 // DeleteMessage removes a message from the queue.
@@ -94,6 +141,16 @@ func (c *Client) DeleteMessage(ctx context.Context, receiptHandle string) error
 	return err
 }
 
+// ExtendVisibility changes the visibility timeout of a received message.
+func (c *Client) ExtendVisibility(ctx context.Context, receiptHandle string, timeout time.Duration) error {
+	_, err := c.svc.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(c.queueURL),
+		ReceiptHandle:     aws.String(receiptHandle),
+		VisibilityTimeout: int32(timeout / time.Second),
+	})
+	return err
+}
+
 // GetQueueAttributes retrieves queue metrics.
 func (c *Client) GetQueueAttributes(ctx context.Context) (map[string]string, error) {
 	out, err := c.svc.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
@@ -110,14 +167,6 @@ func (c *Client) GetQueueAttributes(ctx context.Context) (map[string]string, err
 	return out.Attributes, nil
 }
 
-// Message represents a parsed SQS message
-type Message struct {
-	ID       string
-	Body     string
-	Priority string
-	Receipt  string
-}
-
 // ParseMessage extracts relevant data from SQS message
 func ParseMessage(msg types.Message) (*Message, error) {
 	if msg.Body == nil || msg.MessageId == nil || msg.ReceiptHandle == nil {
@@ -135,5 +184,9 @@ func ParseMessage(msg types.Message) (*Message, error) {
 		m.Priority = aws.ToString(attr.StringValue)
 	}
 
+	if n, err := strconv.Atoi(msg.Attributes["ApproximateReceiveCount"]); err == nil {
+		m.ReceiveCount = n
+	}
+
 	return m, nil
 }
@@ -0,0 +1,109 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/database"
+	"taskqueue/pkg/logger"
+)
+
+// batchSize is how many stuck tasks are loaded per query.
+const batchSize = 100
+
+// Reaper takes back tasks left processing by a worker that crashed or hung.
+// Those whose heartbeat has expired are retried or failed by their retry
+// policy; those running past their timeout, which the worker should have
+// enforced, end timed_out. Several reapers may run against the same
+// database; a task is locked and re-checked before it is reaped, so it is
+// reaped once.
+type Reaper struct {
+	DB       *pgxpool.Pool
+	Interval time.Duration
+
+	// HeartbeatTimeout is how long a processing task may go without a
+	// heartbeat from its worker, and how long past its timeout it may run
+	// before the reaper ends it.
+	HeartbeatTimeout time.Duration
+
+	// Reaped, if set, is called after a task has been reaped with the
+	// reason and the task's new status.
+	Reaped func(st *database.StuckTask, status string)
+}
+
+// New creates a reaper that checks for stuck tasks every interval.
+func New(db *pgxpool.Pool, interval, heartbeatTimeout time.Duration) *Reaper {
+	return &Reaper{
+		DB:               db,
+		Interval:         interval,
+		HeartbeatTimeout: heartbeatTimeout,
+	}
+}
+
+// Run reaps stuck tasks until ctx is cancelled.
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Sweep(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("reaper:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep reaps every task that is currently stuck.
+func (r *Reaper) Sweep(ctx context.Context) error {
+	for {
+		stuck, err := database.ListStuckTasks(ctx, r.DB, r.HeartbeatTimeout, batchSize)
+		if err != nil {
+			return err
+		}
+
+		reaped := 0
+		for i := range stuck {
+			if err := r.reap(ctx, &stuck[i]); err != nil {
+				logger.Error("reaper: task", stuck[i].ID, ":", err)
+				continue
+			}
+			reaped++
+		}
+		if len(stuck) < batchSize || reaped == 0 {
+			return nil
+		}
+	}
+}
+
+func (r *Reaper) reap(ctx context.Context, st *database.StuckTask) error {
+	status, err := database.ReapTask(ctx, r.DB, st, r.message(st))
+	if errors.Is(err, database.ErrTaskNotStuck) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Info("reaper: task", st.ID, "attempt", st.Attempt, "on worker", st.WorkerID,
+		"reaped ("+st.Reason+"), now", status)
+	if r.Reaped != nil {
+		r.Reaped(st, status)
+	}
+	return nil
+}
+
+// message describes why a task was reaped, for its attempt's error.
+func (r *Reaper) message(st *database.StuckTask) string {
+	if st.Reason == database.ReapTimeout {
+		return fmt.Sprintf("task ran longer than its %s timeout", time.Duration(st.TimeoutSeconds)*time.Second)
+	}
+	return fmt.Sprintf("worker %s stopped sending heartbeats", st.WorkerID)
+}
@@ -0,0 +1,262 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/database"
+	"taskqueue/internal/models"
+)
+
+// openTestDB connects to the Postgres database named by TEST_DATABASE_URL
+// and migrates it, skipping the test if the variable is not set.
+func openTestDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	db, err := database.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	if _, err := database.Migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestUser creates a user whose tasks are deleted with it when the test
+// ends.
+func newTestUser(t *testing.T, db *pgxpool.Pool) int64 {
+	t.Helper()
+	ctx := context.Background()
+	googleID := fmt.Sprintf("reaper-test-%d", time.Now().UnixNano())
+	userID, err := database.CreateUser(ctx, db, googleID, googleID+"@example.com", "Reaper Test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(context.Background(), "DELETE FROM users WHERE id=$1", userID)
+	})
+	return userID
+}
+
+// stuckTask describes a processing task for insertProcessing.
+type stuckTask struct {
+	attempt, maxAttempts, timeoutSeconds int
+	// started and heartbeat are how long ago the attempt started and its
+	// worker last sent a heartbeat.
+	started, heartbeat time.Duration
+}
+
+// insertProcessing inserts a task of userID that is processing, with its
+// current attempt, and returns its ID.
+func insertProcessing(t *testing.T, db *pgxpool.Pool, userID int64, st stuckTask) int64 {
+	t.Helper()
+	ctx := context.Background()
+	messageID := fmt.Sprintf("reaper-test-%d-%d", userID, time.Now().UnixNano())
+	var id int64
+	if err := db.QueryRow(ctx, `
+		INSERT INTO tasks (user_id, name, type, priority, status, message_id, worker_id,
+		                   attempt, max_attempts, timeout_seconds, started_at, heartbeat_at)
+		VALUES ($1, 'reaper test', 'email', 'medium', 'processing', $2, 'reaper-test-worker',
+		        $3, $4, $5, CURRENT_TIMESTAMP - make_interval(secs => $6),
+		        CURRENT_TIMESTAMP - make_interval(secs => $7))
+		RETURNING id`,
+		userID, messageID, st.attempt, st.maxAttempts, st.timeoutSeconds,
+		st.started.Seconds(), st.heartbeat.Seconds()).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(ctx, `
+		INSERT INTO task_attempts (task_id, attempt, worker_id, message_id)
+		VALUES ($1, $2, 'reaper-test-worker', $3)`, id, st.attempt, messageID); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// count runs a COUNT query over args and returns the result.
+func count(t *testing.T, db *pgxpool.Pool, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(context.Background(), query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSweep(t *testing.T) {
+	db := openTestDB(t)
+	userID := newTestUser(t, db)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		task    stuckTask
+		status  string
+		attempt string // status of the attempt after the sweep
+		reason  string // empty if the task is not reaped
+	}{
+		{
+			name:    "heartbeat expired with attempts left",
+			task:    stuckTask{attempt: 1, maxAttempts: 3, started: 10 * time.Minute, heartbeat: 10 * time.Minute},
+			status:  "retrying",
+			attempt: "failed",
+			reason:  database.ReapHeartbeatExpired,
+		},
+		{
+			name:    "heartbeat expired on the last attempt",
+			task:    stuckTask{attempt: 3, maxAttempts: 3, started: 10 * time.Minute, heartbeat: 10 * time.Minute},
+			status:  "failed",
+			attempt: "failed",
+			reason:  database.ReapHeartbeatExpired,
+		},
+		{
+			name:    "overran its timeout",
+			task:    stuckTask{attempt: 1, maxAttempts: 3, timeoutSeconds: 60, started: 10 * time.Minute},
+			status:  "timed_out",
+			attempt: "timed_out",
+			reason:  database.ReapTimeout,
+		},
+		{
+			name:    "overran its timeout and heartbeat expired",
+			task:    stuckTask{attempt: 1, maxAttempts: 3, timeoutSeconds: 60, started: 10 * time.Minute, heartbeat: 10 * time.Minute},
+			status:  "timed_out",
+			attempt: "timed_out",
+			reason:  database.ReapTimeout,
+		},
+		{
+			name:    "alive",
+			task:    stuckTask{attempt: 1, maxAttempts: 3, started: 10 * time.Minute},
+			status:  "processing",
+			attempt: "processing",
+		},
+		{
+			name:    "within its timeout",
+			task:    stuckTask{attempt: 1, maxAttempts: 3, timeoutSeconds: 3600, started: 10 * time.Minute},
+			status:  "processing",
+			attempt: "processing",
+		},
+	}
+
+	ids := make([]int64, len(tests))
+	for i, tt := range tests {
+		ids[i] = insertProcessing(t, db, userID, tt.task)
+	}
+
+	reaped := map[int64]string{}
+	r := New(db, time.Minute, time.Minute)
+	r.Reaped = func(st *database.StuckTask, status string) {
+		reaped[st.ID] = status
+	}
+	if err := r.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := ids[i]
+			var status string
+			if err := db.QueryRow(ctx, "SELECT status FROM tasks WHERE id=$1", id).Scan(&status); err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.status {
+				t.Errorf("status = %s, want %s", status, tt.status)
+			}
+			var attempt string
+			if err := db.QueryRow(ctx, "SELECT status FROM task_attempts WHERE task_id=$1 AND attempt=$2",
+				id, tt.task.attempt).Scan(&attempt); err != nil {
+				t.Fatal(err)
+			}
+			if attempt != tt.attempt {
+				t.Errorf("attempt status = %s, want %s", attempt, tt.attempt)
+			}
+
+			if tt.reason == "" {
+				if _, ok := reaped[id]; ok {
+					t.Error("task reaped")
+				}
+				return
+			}
+			if reaped[id] != tt.status {
+				t.Errorf("Reaped called with %q, want %q", reaped[id], tt.status)
+			}
+			if n := count(t, db, "SELECT COUNT(*) FROM task_reaps WHERE task_id=$1 AND reason=$2 AND status=$3",
+				id, tt.reason, tt.status); n != 1 {
+				t.Errorf("%d reaps recorded, want 1", n)
+			}
+
+			retried := count(t, db, "SELECT COUNT(*) FROM task_outbox WHERE task_id=$1 AND published_at IS NULL", id)
+			if want := tt.status == "retrying"; (retried == 1) != want {
+				t.Errorf("%d retries scheduled", retried)
+			}
+			deadLettered := count(t, db, "SELECT COUNT(*) FROM dead_letters WHERE task_id=$1 AND reason='exhausted'", id)
+			if want := tt.status == "failed"; (deadLettered == 1) != want {
+				t.Errorf("%d dead letters recorded", deadLettered)
+			}
+		})
+	}
+
+	// A second sweep finds nothing more to do.
+	clear(reaped)
+	if err := r.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if status, ok := reaped[id]; ok {
+			t.Errorf("task %d reaped again, now %s", id, status)
+		}
+	}
+}
+
+func TestReapTaskChecksAttempt(t *testing.T) {
+	db := openTestDB(t)
+	userID := newTestUser(t, db)
+	ctx := context.Background()
+
+	id := insertProcessing(t, db, userID, stuckTask{
+		attempt: 1, maxAttempts: 3, started: 10 * time.Minute, heartbeat: 10 * time.Minute,
+	})
+	// Two reapers found the same stuck attempt.
+	st := database.StuckTask{
+		Task:   models.Task{ID: id, Attempt: 1, WorkerID: "reaper-test-worker"},
+		Reason: database.ReapHeartbeatExpired,
+	}
+	status, err := database.ReapTask(ctx, db, &st, "worker stopped sending heartbeats")
+	if err != nil || status != "retrying" {
+		t.Fatalf("first reap = %q, %v", status, err)
+	}
+
+	// The retry has been delivered and is processing, and is itself stuck.
+	if _, err := db.Exec(ctx, `
+		UPDATE tasks SET status='processing', attempt=2,
+		       heartbeat_at=CURRENT_TIMESTAMP - interval '10 minutes'
+		WHERE id=$1`, id); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second reaper's view of attempt 1 is stale and must not end
+	// attempt 2.
+	if _, err := database.ReapTask(ctx, db, &st, "worker stopped sending heartbeats"); !errors.Is(err, database.ErrTaskNotStuck) {
+		t.Fatalf("second reap: err = %v, want ErrTaskNotStuck", err)
+	}
+	var attempt int
+	if err := db.QueryRow(ctx, "SELECT status, attempt FROM tasks WHERE id=$1", id).Scan(&status, &attempt); err != nil {
+		t.Fatal(err)
+	}
+	if status != "processing" || attempt != 2 {
+		t.Errorf("task is %s on attempt %d, want processing on attempt 2", status, attempt)
+	}
+	if n := count(t, db, "SELECT COUNT(*) FROM task_reaps WHERE task_id=$1", id); n != 1 {
+		t.Errorf("%d reaps recorded, want 1", n)
+	}
+}
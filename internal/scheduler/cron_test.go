@@ -0,0 +1,79 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// 2026-01-01 is a Thursday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", at(1, 10, 7), at(1, 10, 8)},
+		{"strictly after", "0 10 * * *", at(1, 10, 0), at(2, 10, 0)},
+		{"seconds truncated", "* * * * *", at(1, 10, 7).Add(30 * time.Second), at(1, 10, 8)},
+		{"step", "*/15 * * * *", at(1, 10, 7), at(1, 10, 15)},
+		{"range", "0 9-11 * * *", at(1, 11, 30), at(2, 9, 0)},
+		{"range with step", "0 9-17/4 * * *", at(1, 10, 0), at(1, 13, 0)},
+		{"start with step", "5/20 * * * *", at(1, 10, 30), at(1, 10, 45)},
+		{"start with step wraps", "5/20 * * * *", at(1, 10, 50), at(1, 11, 5)},
+		{"list", "0 0 1,15 * *", at(2, 0, 0), at(15, 0, 0)},
+		{"list of ranges", "0 8-9,17 * * *", at(1, 9, 30), at(1, 17, 0)},
+		{"0 is Sunday", "0 0 * * 0", at(1, 0, 0), at(4, 0, 0)},
+		{"7 is Sunday", "0 0 * * 7", at(1, 0, 0), at(4, 0, 0)},
+		{"month", "0 0 1 3 *", at(1, 0, 0), time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)},
+		{"macro", "@weekly", at(1, 0, 0), at(4, 0, 0)},
+		// Both day fields restricted: either may match.
+		{"day of week or month, weekday first", "0 0 13 * 5", at(1, 0, 0), at(2, 0, 0)},
+		{"day of week or month, day first", "0 0 13 * 5", at(10, 0, 0), at(13, 0, 0)},
+		// A */n day field is unrestricted, so both must match: the first
+		// Monday on an odd day.
+		{"stepped day of month and day of week", "0 0 */2 * 1", at(1, 0, 0), at(5, 0, 0)},
+		{"never", "0 0 30 2 *", at(1, 0, 0), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.spec, err)
+			}
+			if got := c.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"0-60 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+		"@fortnightly",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) succeeded", spec)
+		}
+	}
+}
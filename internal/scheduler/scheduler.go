@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/database"
+	"taskqueue/internal/models"
+	"taskqueue/pkg/logger"
+)
+
+// leaderLockKey is the advisory lock key held by the active scheduler.
+const leaderLockKey int64 = 0x7471_7363_6864 // "tqschd"
+
+// dueBatch is how many due schedules are loaded per query.
+const dueBatch = 100
+
+// SubmitFunc creates a task and schedules it for the queue.
+type SubmitFunc func(ctx context.Context, task *models.Task) error
+
+// Scheduler turns due schedules into tasks. Every server replica may run
+// one; only the replica holding the leader advisory lock fires schedules.
+//
+// A schedule is advanced after its task has been submitted, so a crash in
+// between can fire it twice but never skips it.
+type Scheduler struct {
+	DB       *pgxpool.Pool
+	Submit   SubmitFunc
+	Interval time.Duration
+
+	leader *database.Leader
+}
+
+// New creates a scheduler that checks for due schedules every interval.
+func New(db *pgxpool.Pool, submit SubmitFunc, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		DB:       db,
+		Submit:   submit,
+		Interval: interval,
+	}
+}
+
+// Run fires due schedules until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	defer func() {
+		if s.leader != nil {
+			s.leader.Release(context.WithoutCancel(ctx))
+		}
+	}()
+
+	for {
+		if s.lead(ctx) {
+			if err := s.Tick(ctx, time.Now().UTC()); err != nil && ctx.Err() == nil {
+				logger.Error("scheduler:", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead reports whether this replica holds the leader lock, trying to take
+// it if not.
+func (s *Scheduler) lead(ctx context.Context) bool {
+	if s.leader != nil {
+		if err := s.leader.Check(ctx); err == nil {
+			return true
+		}
+		logger.Error("scheduler: lost leader connection")
+		s.leader.Release(ctx)
+		s.leader = nil
+	}
+
+	leader, err := database.TryLead(ctx, s.DB, leaderLockKey)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error("scheduler: leader election:", err)
+		}
+		return false
+	}
+	if leader == nil {
+		return false
+	}
+	logger.Info("scheduler: elected leader")
+	s.leader = leader
+	return true
+}
+
+// Tick fires every schedule due at now. A schedule whose task cannot be
+// submitted is left due and retried on the next tick.
+func (s *Scheduler) Tick(ctx context.Context, now time.Time) error {
+	for {
+		due, err := database.ListDueSchedules(ctx, s.DB, now, dueBatch)
+		if err != nil {
+			return err
+		}
+
+		fired := 0
+		for i := range due {
+			if err := s.fire(ctx, &due[i], now); err != nil {
+				logger.Error("scheduler: schedule", due[i].ID, ":", err)
+				continue
+			}
+			fired++
+		}
+		if len(due) < dueBatch || fired == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *Scheduler) fire(ctx context.Context, sched *models.Schedule, now time.Time) error {
+	// Work out the next run first so a corrupt expression does not
+	// create a task on every tick.
+	var next time.Time
+	if sched.Cron != "" {
+		c, err := ParseCron(sched.Cron)
+		if err != nil {
+			if markErr := database.MarkScheduleRun(ctx, s.DB, sched.ID, sched.LastTaskID, sched.LastRunAt, time.Time{}); markErr != nil {
+				return markErr
+			}
+			return fmt.Errorf("disabled: %w", err)
+		}
+		next = c.Next(now)
+	}
+
+	task := sched.NewTask()
+	if err := s.Submit(ctx, task); err != nil {
+		return err
+	}
+	logger.Info("scheduler: schedule", sched.ID, "created task", task.ID)
+
+	return database.MarkScheduleRun(ctx, s.DB, sched.ID, task.ID, now, next)
+}
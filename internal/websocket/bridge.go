@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/database"
+)
+
+// ErrMessageTooLarge is returned by a bridge that cannot carry a message.
+var ErrMessageTooLarge = errors.New("message too large for bridge")
+
+// Bridge carries hub messages between server replicas, so each replica can
+// deliver them to its own clients.
+type Bridge interface {
+	// Publish sends msg to every replica, including this one.
+	Publish(ctx context.Context, msg []byte) error
+	// Run calls deliver with each message published by any replica until
+	// ctx is cancelled.
+	Run(ctx context.Context, deliver func(msg []byte))
+}
+
+// OpenBridge returns the bridge named kind: "postgres", or "none" for a
+// single replica, in which case it returns nil.
+func OpenBridge(kind string, db *pgxpool.Pool) (Bridge, error) {
+	switch kind {
+	case "postgres":
+		return NewPostgresBridge(db), nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown hub bridge %q", kind)
+	}
+}
+
+// bridgeChannel is the notification channel used by PostgresBridge.
+const bridgeChannel = "hub_messages"
+
+// PostgresBridge is a Bridge over Postgres LISTEN/NOTIFY. Messages larger
+// than a notification payload cannot be published; the hub keeps its
+// messages small by sending recorded events by ID.
+type PostgresBridge struct {
+	DB *pgxpool.Pool
+}
+
+// NewPostgresBridge creates a bridge through the database shared by all
+// replicas.
+func NewPostgresBridge(db *pgxpool.Pool) *PostgresBridge {
+	return &PostgresBridge{DB: db}
+}
+
+// Publish notifies every listening replica of msg.
+func (b *PostgresBridge) Publish(ctx context.Context, msg []byte) error {
+	if len(msg) > database.MaxNotifyPayload {
+		return ErrMessageTooLarge
+	}
+	return database.Notify(ctx, b.DB, bridgeChannel, string(msg))
+}
+
+// Run listens for published messages, reconnecting after connection errors.
+// Messages published while reconnecting are lost.
+func (b *PostgresBridge) Run(ctx context.Context, deliver func(msg []byte)) {
+	retryListen(ctx, "hub bridge", func(ctx context.Context) error {
+		return database.Listen(ctx, b.DB, bridgeChannel, func(payload string) {
+			deliver([]byte(payload))
+		})
+	})
+}
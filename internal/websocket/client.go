@@ -3,6 +3,7 @@ package websocket
 import (
 	"bytes"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -20,8 +21,15 @@ const (
 
 	// Maximum message size allowed from peer.
 	maxMessageSize = 512
+
+	// Buffered messages per client; a client falling further behind is
+	// evicted.
+	sendBufferSize = 256
 )
 
+// closeSlowConsumer is the close reason sent to an evicted client.
+const closeSlowConsumer = "slow consumer"
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -33,6 +41,24 @@ type Client struct {
 	conn   *websocket.Conn
 	send   chan []byte
 	userID int64
+
+	// closeReason is set by the hub before it closes send when evicting
+	// the client.
+	closeReason string
+
+	// subs limits the events sent to the client, as requested by it.
+	subs subscriptions
+
+	// replay holds live events back while missed events are replayed.
+	replayMu  sync.Mutex
+	replay    []pendingMessage
+	replaying bool
+}
+
+// pendingMessage is a live event held back during a replay.
+type pendingMessage struct {
+	id   int64
+	data []byte
 }
 
 // NewClient creates a new WebSocket client
@@ -40,15 +66,16 @@ func NewClient(hub *Hub, conn *websocket.Conn, userID int64) *Client {
 	return &Client{
 		hub:    hub,
 		conn:   conn,
-		send:   make(chan []byte, 256),
+		send:   make(chan []byte, sendBufferSize),
 		userID: userID,
 	}
 }
 
-// ReadPump pumps messages from the websocket connection to the hub.
+// ReadPump pumps messages from the websocket connection to the hub. Each
+// message is a subscription or resume request, answered on the connection.
 func (c *Client) ReadPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.Unregister(c)
 		c.conn.Close()
 	}()
 	
@@ -68,7 +95,7 @@ func (c *Client) ReadPump() {
 			break
 		}
 		message = bytes.TrimSpace(bytes.Replace(message, []byte{'\n'}, []byte{' '}, -1))
-		// We don't process incoming messages for now, but we could handle them here
+		c.hub.handleRequest(c, message)
 	}
 }
 
@@ -86,24 +113,19 @@ func (c *Client) WritePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-			
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+				if c.closeReason != "" {
+					// Evicted; the client may reconnect and resume.
+					c.conn.WriteMessage(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseTryAgainLater, c.closeReason))
+				} else {
+					c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				}
 				return
 			}
-			w.Write(message)
 			
-			// Add queued messages to the current websocket message.
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
-			}
-			
-			if err := w.Close(); err != nil {
+			// Each message is a frame of its own, so clients can parse
+			// every frame as one JSON document.
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 			
@@ -114,4 +136,32 @@ func (c *Client) WritePump() {
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+// startReplay starts holding back live events.
+func (c *Client) startReplay() {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	c.replaying = true
+}
+
+// holdForReplay keeps a live event back if a replay is in progress and
+// reports whether it did.
+func (c *Client) holdForReplay(id int64, data []byte) bool {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	if !c.replaying {
+		return false
+	}
+	c.replay = append(c.replay, pendingMessage{id: id, data: data})
+	return true
+}
+
+// finishReplay stops holding back live events and returns those held.
+func (c *Client) finishReplay() []pendingMessage {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	held := c.replay
+	c.replay, c.replaying = nil, false
+	return held
+}
@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/database"
+	"taskqueue/pkg/logger"
+)
+
+// maxReplayEvents is the most events replayed to a resuming client; one
+// that missed more is told to resync instead.
+const maxReplayEvents = 200
+
+// replayWindow is how long before the last event a client saw a replay also
+// looks for events, to find those that committed after it despite a lower
+// ID. Transactions that record events must take less than this.
+const replayWindow = time.Minute
+
+// pruneInterval is how often PostgresEventStore deletes expired events.
+const pruneInterval = time.Hour
+
+// EventStore records hub events so that reconnecting clients can replay
+// the ones they missed.
+type EventStore interface {
+	// Append records e and sets e.ID. IDs increase monotonically.
+	Append(ctx context.Context, e *Event) error
+	// Get returns the recorded event with id.
+	Get(ctx context.Context, id int64) (*Event, error)
+	// Since returns up to limit of a user's events after afterID, oldest
+	// first. Since event IDs are not assigned in commit order, it may also
+	// return events with lower IDs that were recorded shortly before
+	// afterID, which the client may already have; clients drop events whose
+	// ID they have seen. complete is false if some events after afterID may
+	// no longer be stored.
+	Since(ctx context.Context, userID, afterID int64, limit int) (events []Event, complete bool, err error)
+}
+
+// PostgresEventStore is an EventStore over the task_events table, which
+// the notify_task_status trigger also writes to.
+type PostgresEventStore struct {
+	DB *pgxpool.Pool
+	// Retention is how long events are kept.
+	Retention time.Duration
+}
+
+// NewPostgresEventStore creates a store keeping events for retention.
+func NewPostgresEventStore(db *pgxpool.Pool, retention time.Duration) *PostgresEventStore {
+	return &PostgresEventStore{DB: db, Retention: retention}
+}
+
+// Append records e.
+func (s *PostgresEventStore) Append(ctx context.Context, e *Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	te := &database.TaskEvent{
+		UserID:     e.UserID,
+		Type:       e.Type,
+		TaskID:     e.TaskID,
+		WorkflowID: e.WorkflowID,
+		TaskType:   e.TaskType,
+		Data:       data,
+	}
+	if err := database.AppendTaskEvent(ctx, s.DB, te); err != nil {
+		return err
+	}
+	e.ID = te.ID
+	return nil
+}
+
+// Get returns the event with id.
+func (s *PostgresEventStore) Get(ctx context.Context, id int64) (*Event, error) {
+	te, err := database.GetTaskEvent(ctx, s.DB, id)
+	if err != nil {
+		return nil, err
+	}
+	e := eventFromTaskEvent(te)
+	return &e, nil
+}
+
+// Since returns the user's events after afterID, and those recorded within
+// replayWindow before it.
+func (s *PostgresEventStore) Since(ctx context.Context, userID, afterID int64, limit int) ([]Event, bool, error) {
+	// Events before the oldest one kept may have been pruned.
+	oldest, err := database.OldestTaskEventID(ctx, s.DB)
+	if err != nil {
+		return nil, false, err
+	}
+	if oldest > afterID+1 {
+		return nil, false, nil
+	}
+
+	stored, err := database.ListTaskEventsSince(ctx, s.DB, userID, afterID, replayWindow, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(stored) > limit {
+		return nil, false, nil
+	}
+
+	events := make([]Event, len(stored))
+	for i := range stored {
+		events[i] = eventFromTaskEvent(&stored[i])
+	}
+	return events, true, nil
+}
+
+func eventFromTaskEvent(te *database.TaskEvent) Event {
+	return Event{
+		ID:         te.ID,
+		UserID:     te.UserID,
+		Type:       te.Type,
+		TaskID:     te.TaskID,
+		WorkflowID: te.WorkflowID,
+		TaskType:   te.TaskType,
+		Data:       json.RawMessage(te.Data),
+	}
+}
+
+// Run deletes events older than the retention period until ctx is
+// cancelled.
+func (s *PostgresEventStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := database.PruneTaskEvents(ctx, s.DB, s.Retention)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("prune task events:", err)
+		} else if n > 0 {
+			logger.Info("pruned", n, "task events")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
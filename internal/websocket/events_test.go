@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/database"
+)
+
+// openTestDB connects to the Postgres database named by TEST_DATABASE_URL
+// and migrates it, skipping the test if the variable is not set.
+func openTestDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	db, err := database.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	if _, err := database.Migrate(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestUser creates a user whose events are deleted with it when the test
+// ends.
+func newTestUser(t *testing.T, db *pgxpool.Pool) int64 {
+	t.Helper()
+	ctx := context.Background()
+	googleID := fmt.Sprintf("events-test-%d", time.Now().UnixNano())
+	userID, err := database.CreateUser(ctx, db, googleID, googleID+"@example.com", "Events Test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(context.Background(), "DELETE FROM users WHERE id=$1", userID)
+	})
+	return userID
+}
+
+func TestPostgresEventStoreReplaysLateCommits(t *testing.T) {
+	db := openTestDB(t)
+	userID := newTestUser(t, db)
+	store := NewPostgresEventStore(db, time.Hour)
+	ctx := context.Background()
+
+	// An event from well before the window is not replayed again.
+	var oldID int64
+	if err := db.QueryRow(ctx, `
+		INSERT INTO task_events (user_id, type, created_at)
+		VALUES ($1, 'task_updated', CURRENT_TIMESTAMP - interval '2 minutes')
+		RETURNING id`, userID).Scan(&oldID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The late event takes its ID inside a transaction, as the status
+	// trigger does, and commits after a later event the client has seen.
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	var lateID int64
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO task_events (user_id, type) VALUES ($1, 'task_updated')
+		RETURNING id`, userID).Scan(&lateID); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := &Event{UserID: userID, Type: "task_created", Data: map[string]int{"n": 1}}
+	if err := store.Append(ctx, seen); err != nil {
+		t.Fatal(err)
+	}
+	if seen.ID <= lateID {
+		t.Fatalf("seen event %d was numbered before late event %d", seen.ID, lateID)
+	}
+
+	// Before the late event commits it cannot be replayed.
+	events, complete, err := store.Since(ctx, userID, seen.ID, maxReplayEvents)
+	if err != nil || !complete {
+		t.Fatalf("Since = %v, %v", complete, err)
+	}
+	for _, e := range events {
+		if e.ID == lateID {
+			t.Fatal("uncommitted event replayed")
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	after := &Event{UserID: userID, Type: "task_updated"}
+	if err := store.Append(ctx, after); err != nil {
+		t.Fatal(err)
+	}
+
+	events, complete, err = store.Since(ctx, userID, seen.ID, maxReplayEvents)
+	if err != nil || !complete {
+		t.Fatalf("Since = %v, %v", complete, err)
+	}
+	got := map[int64]bool{}
+	for _, e := range events {
+		got[e.ID] = true
+	}
+	if !got[lateID] {
+		t.Errorf("late event %d not replayed after %d; got %v", lateID, seen.ID, got)
+	}
+	if !got[after.ID] {
+		t.Errorf("event %d after %d not replayed; got %v", after.ID, seen.ID, got)
+	}
+	if got[oldID] {
+		t.Errorf("event %d from before the window replayed", oldID)
+	}
+}
+
+func TestPostgresEventStoreGet(t *testing.T) {
+	db := openTestDB(t)
+	userID := newTestUser(t, db)
+	store := NewPostgresEventStore(db, time.Hour)
+	ctx := context.Background()
+
+	e := &Event{UserID: userID, Type: "task_created", TaskType: "email", Data: map[string]string{"name": "a"}}
+	if err := store.Append(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(ctx, e.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != e.ID || got.UserID != userID || got.Type != "task_created" || got.TaskType != "email" {
+		t.Errorf("got %+v", got)
+	}
+	if data := fmt.Sprintf("%s", got.Data); data != `{"name": "a"}` {
+		t.Errorf("data = %s", data)
+	}
+}
@@ -1,59 +1,126 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
-	"sync"
+	"fmt"
+	"time"
+
+	"taskqueue/pkg/logger"
 )
 
-// Hub maintains the set of active clients and broadcasts messages to the clients.
+// publishTimeout bounds publishing a message through the bridge.
+const publishTimeout = 5 * time.Second
+
+// opQueueSize is how many hub operations can wait for the owner goroutine
+// before callers block.
+const opQueueSize = 256
+
+// Hub maintains the set of active clients and broadcasts messages to the
+// clients. Clients are indexed by user, and every change to the index and
+// every send to a client happens on the goroutine running Run, which owns
+// them; other methods queue operations for it. Operations queued by one
+// goroutine run in the order queued.
+//
+// A client whose send buffer is full when a message arrives is too slow to
+// keep up: it is evicted, which removes it from the index and closes its
+// send channel, once, so its connection is closed.
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	mu         sync.RWMutex
+	// Bridge, if set, carries messages to the clients connected to other
+	// server replicas. It must be set before the hub is used.
+	Bridge Bridge
+
+	// Events, if set, records events for replay to reconnecting clients.
+	// It must be set before the hub is used.
+	Events EventStore
+
+	ops chan func()
+
+	// users indexes registered clients by user; owned by Run.
+	users map[int64]map[*Client]bool
 }
 
 // NewHub creates a new WebSocket hub
 func NewHub() *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
+		ops:   make(chan func(), opQueueSize),
+		users: make(map[int64]map[*Client]bool),
 	}
 }
 
 // Run starts the hub
 func (h *Hub) Run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = true
-			h.mu.Unlock()
-
-		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-			}
-			h.mu.Unlock()
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
-			h.mu.RUnlock()
+	for op := range h.ops {
+		op()
+	}
+}
+
+// Register adds a client to the hub
+func (h *Hub) Register(client *Client) {
+	h.ops <- func() {
+		clients := h.users[client.userID]
+		if clients == nil {
+			clients = make(map[*Client]bool)
+			h.users[client.userID] = clients
+		}
+		clients[client] = true
+	}
+}
+
+// Unregister removes a client from the hub and closes its send channel, if
+// it has not been evicted already.
+func (h *Hub) Unregister(client *Client) {
+	h.ops <- func() {
+		h.remove(client, "")
+	}
+}
+
+// remove takes client out of the index and closes its send channel, telling
+// it why if reason is set. It is a no-op for a client no longer registered,
+// so send is closed exactly once. Only called by Run.
+func (h *Hub) remove(client *Client, reason string) {
+	clients := h.users[client.userID]
+	if !clients[client] {
+		return
+	}
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(h.users, client.userID)
+	}
+	client.closeReason = reason
+	close(client.send)
+}
+
+// send queues message for client, evicting the client if its buffer is
+// full. It reports whether the client is still registered. Only called by
+// Run.
+func (h *Hub) send(client *Client, message []byte) bool {
+	select {
+	case client.send <- message:
+		return true
+	default:
+		logger.Info("websocket: evicting slow client of user", client.userID)
+		h.remove(client, closeSlowConsumer)
+		return false
+	}
+}
+
+// registered reports whether client is in the index. Only called by Run.
+func (h *Hub) registered(client *Client) bool {
+	return h.users[client.userID][client]
+}
+
+// clientCount returns the number of registered clients.
+func (h *Hub) clientCount() int {
+	result := make(chan int)
+	h.ops <- func() {
+		n := 0
+		for _, clients := range h.users {
+			n += len(clients)
 		}
+		result <- n
 	}
+	return <-result
 }
 
 // BroadcastTaskUpdate sends a task update to all connected clients
@@ -69,45 +136,225 @@ func (h *Hub) BroadcastTaskUpdate(userID int64, updateType string, data interfac
 		return err
 	}
 
-	h.broadcast <- jsonData
+	h.ops <- func() {
+		for _, clients := range h.users {
+			for client := range clients {
+				h.send(client, jsonData)
+			}
+		}
+	}
 	return nil
 }
 
-// BroadcastToUser sends a message to all clients of a specific user
+// BroadcastToUser sends a message to all clients of a specific user that
+// are not limited to particular tasks, on every replica when the hub has a
+// bridge.
 func (h *Hub) BroadcastToUser(userID int64, updateType string, data interface{}) error {
-	message := map[string]interface{}{
-		"type": updateType,
-		"data": data,
+	return h.Broadcast(&Event{UserID: userID, Type: updateType, Data: data})
+}
+
+// bridgeMessage is published through the bridge: a recorded event by ID,
+// which every replica loads from its event store, so events of any size can
+// cross, or an event that could not be recorded in full.
+type bridgeMessage struct {
+	EventID int64  `json:"event_id,omitempty"`
+	Event   *Event `json:"event,omitempty"`
+}
+
+// Broadcast records e, which assigns its ID, and sends it to the clients of
+// e.UserID whose subscriptions match it, on every replica when the hub has a
+// bridge.
+func (h *Hub) Broadcast(e *Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	recorded := false
+	if h.Events != nil {
+		if err := h.Events.Append(ctx, e); err != nil {
+			// The event is still sent, but cannot be replayed.
+			logger.Error("hub: record event:", err)
+		} else {
+			recorded = true
+		}
 	}
 
-	jsonData, err := json.Marshal(message)
+	if h.Bridge == nil {
+		return h.SendLocal(e)
+	}
+
+	bm := bridgeMessage{Event: e}
+	if recorded {
+		bm = bridgeMessage{EventID: e.ID}
+	}
+	msg, err := json.Marshal(bm)
 	if err != nil {
 		return err
 	}
+	if err := h.Bridge.Publish(ctx, msg); err != nil {
+		// Other replicas miss the event, but local clients still get it.
+		logger.Error("hub bridge publish:", err)
+		h.SendLocal(e)
+		return err
+	}
+	return nil
+}
 
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// SendLocal sends e to the matching clients connected to this replica
+// only, for events every replica learns of by itself.
+func (h *Hub) SendLocal(e *Event) error {
+	jsonData, err := encodeMessage(e.ID, e.Type, e.Data)
+	if err != nil {
+		return err
+	}
 
-	for client := range h.clients {
-		if client.userID == userID {
-			select {
-			case client.send <- jsonData:
-			default:
-				close(client.send)
-				delete(h.clients, client)
+	h.ops <- func() {
+		for client := range h.users[e.UserID] {
+			if client.subs.matches(e) && !client.holdForReplay(e.ID, jsonData) {
+				h.send(client, jsonData)
 			}
 		}
 	}
-
 	return nil
 }
 
-// Register adds a client to the hub
-func (h *Hub) Register(client *Client) {
-	h.register <- client
+// handleRequest answers a message sent by client.
+func (h *Hub) handleRequest(client *Client, raw []byte) {
+	var req clientRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		h.reply(client, replyError(errBadRequest))
+		return
+	}
+	if req.Action == "resume" {
+		h.resume(client, req.LastEventID)
+		return
+	}
+	h.reply(client, client.subs.handle(&req))
 }
 
-// Unregister removes a client from the hub
-func (h *Hub) Unregister(client *Client) {
-	h.unregister <- client
-}
\ No newline at end of file
+// resume sends client the events matching its subscriptions that it missed
+// after lastEventID, followed by a resumed message, before any live events.
+// A client that missed more than can be replayed is sent a resync message
+// instead, telling it to reload its state.
+func (h *Hub) resume(client *Client, lastEventID int64) {
+	// Live events arriving meanwhile are held back until the replay is sent.
+	client.startReplay()
+
+	var events []Event
+	complete := false
+	if h.Events != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		var err error
+		events, complete, err = h.Events.Since(ctx, client.userID, lastEventID, maxReplayEvents)
+		cancel()
+		if err != nil {
+			logger.Error("hub: replay events:", err)
+			complete = false
+		}
+	}
+
+	var messages [][]byte
+	replayed := map[int64]bool{}
+	if complete {
+		for i := range events {
+			e := &events[i]
+			replayed[e.ID] = true
+			if !client.subs.matches(e) {
+				continue
+			}
+			if msg, err := encodeMessage(e.ID, e.Type, e.Data); err == nil {
+				messages = append(messages, msg)
+			}
+		}
+		msg, _ := encodeMessage(0, "resumed", map[string]int{"replayed": len(messages)})
+		messages = append(messages, msg)
+	} else {
+		msg, _ := encodeMessage(0, "resync", nil)
+		messages = append(messages, msg)
+	}
+
+	h.ops <- func() {
+		// Live events not already replayed follow the replay.
+		for _, p := range client.finishReplay() {
+			if p.id == 0 || !replayed[p.id] {
+				messages = append(messages, p.data)
+			}
+		}
+		if !h.registered(client) {
+			return
+		}
+		for _, msg := range messages {
+			if !h.send(client, msg) {
+				return
+			}
+		}
+	}
+}
+
+// reply sends a response to a request from client, unless the client has
+// already been unregistered.
+func (h *Hub) reply(client *Client, message []byte) {
+	h.ops <- func() {
+		if h.registered(client) {
+			h.send(client, message)
+		}
+	}
+}
+
+// RunBridge delivers events published through the bridge by any replica
+// to the local clients they are addressed to, until ctx is cancelled.
+func (h *Hub) RunBridge(ctx context.Context) {
+	if h.Bridge == nil {
+		return
+	}
+	h.Bridge.Run(ctx, func(msg []byte) {
+		e, err := h.bridgedEvent(ctx, msg)
+		if err != nil {
+			logger.Error("hub bridge:", err)
+			return
+		}
+		h.SendLocal(e)
+	})
+}
+
+// bridgedEvent returns the event carried by a bridge message, loading it
+// from the event store if the message only names it.
+func (h *Hub) bridgedEvent(ctx context.Context, msg []byte) (*Event, error) {
+	var bm struct {
+		EventID int64 `json:"event_id"`
+		Event   *struct {
+			Event
+			Data json.RawMessage `json:"data"`
+		} `json:"event"`
+	}
+	if err := json.Unmarshal(msg, &bm); err != nil {
+		return nil, fmt.Errorf("bad message: %w", err)
+	}
+	if bm.Event != nil {
+		bm.Event.Event.Data = bm.Event.Data
+		return &bm.Event.Event, nil
+	}
+	if bm.EventID == 0 || h.Events == nil {
+		return nil, fmt.Errorf("cannot load event %d", bm.EventID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+	e, err := h.Events.Get(ctx, bm.EventID)
+	if err != nil {
+		return nil, fmt.Errorf("load event %d: %w", bm.EventID, err)
+	}
+	return e, nil
+}
+
+// encodeMessage returns a message as sent to clients. Messages that are
+// not recorded events have no ID.
+func encodeMessage(id int64, updateType string, data interface{}) ([]byte, error) {
+	message := map[string]interface{}{
+		"type": updateType,
+		"data": data,
+	}
+	if id != 0 {
+		message["id"] = id
+	}
+	return json.Marshal(message)
+}
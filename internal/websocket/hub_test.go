@@ -0,0 +1,417 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	h := NewHub()
+	go h.Run()
+	return h
+}
+
+func newTestClient(h *Hub, userID int64) *Client {
+	c := NewClient(h, nil, userID)
+	h.Register(c)
+	return c
+}
+
+// received drains the messages buffered for c, after waiting for the hub to
+// run every operation queued so far.
+func received(t *testing.T, h *Hub, c *Client) []map[string]interface{} {
+	t.Helper()
+	h.clientCount()
+
+	var messages []map[string]interface{}
+	for {
+		select {
+		case raw, ok := <-c.send:
+			if !ok {
+				return messages
+			}
+			var m map[string]interface{}
+			if err := json.Unmarshal(raw, &m); err != nil {
+				t.Fatalf("bad message %s: %v", raw, err)
+			}
+			messages = append(messages, m)
+		default:
+			return messages
+		}
+	}
+}
+
+func types(messages []map[string]interface{}) []string {
+	list := make([]string, len(messages))
+	for i, m := range messages {
+		list[i] = fmt.Sprint(m["type"])
+	}
+	return list
+}
+
+func TestSendLocalRoutesByUser(t *testing.T) {
+	h := newTestHub(t)
+	alice1 := newTestClient(h, 1)
+	alice2 := newTestClient(h, 1)
+	bob := newTestClient(h, 2)
+
+	h.SendLocal(&Event{UserID: 1, Type: "task_updated", TaskID: 10})
+
+	for _, c := range []*Client{alice1, alice2} {
+		if got := types(received(t, h, c)); len(got) != 1 || got[0] != "task_updated" {
+			t.Errorf("user 1 client got %v, want [task_updated]", got)
+		}
+	}
+	if got := received(t, h, bob); len(got) != 0 {
+		t.Errorf("user 2 client got %v, want nothing", types(got))
+	}
+}
+
+func TestSubscriptionsFilterEvents(t *testing.T) {
+	h := newTestHub(t)
+	all := newTestClient(h, 1)
+	one := newTestClient(h, 1)
+
+	h.handleRequest(one, []byte(`{"action": "subscribe", "task_id": 10}`))
+	if got := types(received(t, h, one)); len(got) != 1 || got[0] != "subscribed" {
+		t.Fatalf("subscribe reply = %v, want [subscribed]", got)
+	}
+
+	h.SendLocal(&Event{UserID: 1, Type: "task_updated", TaskID: 10})
+	h.SendLocal(&Event{UserID: 1, Type: "task_updated", TaskID: 11})
+	h.SendLocal(&Event{UserID: 1, Type: "stats_update"})
+
+	if got := received(t, h, all); len(got) != 3 {
+		t.Errorf("unsubscribed client got %d messages, want 3", len(got))
+	}
+	if got := received(t, h, one); len(got) != 1 {
+		t.Errorf("subscribed client got %v, want one message", types(got))
+	}
+
+	h.handleRequest(one, []byte(`{"action": "subscribe"}`))
+	if got := types(received(t, h, one)); len(got) != 1 || got[0] != "error" {
+		t.Errorf("bad request reply = %v, want [error]", got)
+	}
+}
+
+func TestSlowConsumerIsEvicted(t *testing.T) {
+	h := newTestHub(t)
+	slow := newTestClient(h, 1)
+	fast := newTestClient(h, 1)
+
+	for i := 0; i < sendBufferSize+1; i++ {
+		h.SendLocal(&Event{UserID: 1, Type: "task_updated"})
+		// The fast client keeps up.
+		if got := received(t, h, fast); len(got) != 1 {
+			t.Fatalf("fast client got %d messages, want 1", len(got))
+		}
+	}
+
+	n := 0
+	for range slow.send {
+		n++
+	}
+	if n != sendBufferSize {
+		t.Errorf("slow client got %d messages before eviction, want %d", n, sendBufferSize)
+	}
+	if slow.closeReason != closeSlowConsumer {
+		t.Errorf("close reason = %q, want %q", slow.closeReason, closeSlowConsumer)
+	}
+	if got := h.clientCount(); got != 1 {
+		t.Errorf("clientCount = %d, want 1", got)
+	}
+
+	// Unregistering an evicted client must not close send again.
+	h.Unregister(slow)
+	h.reply(slow, []byte(`{}`))
+	h.clientCount()
+}
+
+func TestUnregisterTwice(t *testing.T) {
+	h := newTestHub(t)
+	c := newTestClient(h, 1)
+
+	h.Unregister(c)
+	h.Unregister(c)
+	if got := h.clientCount(); got != 0 {
+		t.Errorf("clientCount = %d, want 0", got)
+	}
+	if _, ok := <-c.send; ok {
+		t.Error("send is still open")
+	}
+}
+
+func TestConcurrentBroadcastRegisterUnregister(t *testing.T) {
+	h := newTestHub(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Broadcasters keep sending to every user while clients come and go.
+	var broadcasters sync.WaitGroup
+	for b := 0; b < 4; b++ {
+		broadcasters.Add(1)
+		go func() {
+			defer broadcasters.Done()
+			for ctx.Err() == nil {
+				for user := int64(1); user <= 3; user++ {
+					h.SendLocal(&Event{UserID: user, Type: "task_updated", TaskID: user})
+				}
+			}
+		}()
+	}
+
+	var clients sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		clients.Add(1)
+		go func(i int) {
+			defer clients.Done()
+			c := newTestClient(h, int64(i%3)+1)
+			if i%2 == 0 {
+				h.handleRequest(c, []byte(`{"action": "subscribe", "task_id": 1}`))
+			}
+			// Read a little, then leave; some are evicted first.
+			deadline := time.After(time.Duration(i%5) * time.Millisecond)
+		read:
+			for {
+				select {
+				case _, ok := <-c.send:
+					if !ok {
+						break read
+					}
+				case <-deadline:
+					break read
+				}
+			}
+			h.Unregister(c)
+		}(i)
+	}
+
+	clients.Wait()
+	cancel()
+	broadcasters.Wait()
+
+	if got := h.clientCount(); got != 0 {
+		t.Errorf("clientCount = %d, want 0", got)
+	}
+}
+
+// blockingStore is an EventStore whose Since waits until released, so live
+// events can arrive during a replay.
+type blockingStore struct {
+	events  []Event
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) Append(ctx context.Context, e *Event) error {
+	return nil
+}
+
+func (s *blockingStore) Get(ctx context.Context, id int64) (*Event, error) {
+	return nil, fmt.Errorf("event %d not found", id)
+}
+
+func (s *blockingStore) Since(ctx context.Context, userID, afterID int64, limit int) ([]Event, bool, error) {
+	close(s.started)
+	<-s.release
+	var events []Event
+	for _, e := range s.events {
+		if e.UserID == userID && e.ID > afterID {
+			events = append(events, e)
+		}
+	}
+	return events, true, nil
+}
+
+func TestResumeReplaysBeforeLiveEvents(t *testing.T) {
+	store := &blockingStore{
+		events: []Event{
+			{ID: 1, UserID: 1, Type: "task_created"},
+			{ID: 2, UserID: 2, Type: "task_created"},
+			{ID: 3, UserID: 1, Type: "task_updated"},
+			{ID: 4, UserID: 1, Type: "task_updated"},
+		},
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	h := newTestHub(t)
+	h.Events = store
+	c := newTestClient(h, 1)
+
+	done := make(chan struct{})
+	go func() {
+		h.resume(c, 1)
+		close(done)
+	}()
+
+	<-store.started
+	// Event 4 was stored before the replay query and is also sent live;
+	// event 5 only arrives live.
+	h.SendLocal(&Event{ID: 4, UserID: 1, Type: "task_updated"})
+	h.SendLocal(&Event{ID: 5, UserID: 1, Type: "task_cancelled"})
+	if got := received(t, h, c); len(got) != 0 {
+		t.Fatalf("live events sent during replay: %v", types(got))
+	}
+	close(store.release)
+	<-done
+
+	var ids []interface{}
+	got := received(t, h, c)
+	for _, m := range got {
+		ids = append(ids, m["id"])
+	}
+	want := "[3 4 <nil> 5]"
+	if fmt.Sprint(ids) != want || got[2]["type"] != "resumed" {
+		t.Errorf("got ids %v (%v), want %s with resumed third", ids, types(got), want)
+	}
+}
+
+func TestResumeWithoutStoreResyncs(t *testing.T) {
+	h := newTestHub(t)
+	c := newTestClient(h, 1)
+
+	h.handleRequest(c, []byte(`{"action": "resume", "last_event_id": 7}`))
+	if got := types(received(t, h, c)); len(got) != 1 || got[0] != "resync" {
+		t.Errorf("got %v, want [resync]", got)
+	}
+}
+
+// memoryEvents is an EventStore in memory.
+type memoryEvents struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *memoryEvents) Append(ctx context.Context, e *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = int64(len(s.events)) + 1
+	s.events = append(s.events, *e)
+	return nil
+}
+
+func (s *memoryEvents) Get(ctx context.Context, id int64) (*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 1 || id > int64(len(s.events)) {
+		return nil, fmt.Errorf("event %d not found", id)
+	}
+	e := s.events[id-1]
+	return &e, nil
+}
+
+func (s *memoryEvents) Since(ctx context.Context, userID, afterID int64, limit int) ([]Event, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []Event
+	for _, e := range s.events {
+		if e.UserID == userID && e.ID > afterID {
+			events = append(events, e)
+		}
+	}
+	return events, true, nil
+}
+
+// loopBridge connects hubs in one process. Like PostgresBridge it refuses
+// messages over maxPayload.
+type loopBridge struct {
+	maxPayload int
+
+	mu       sync.Mutex
+	replicas []func(msg []byte)
+}
+
+func (b *loopBridge) Publish(ctx context.Context, msg []byte) error {
+	if len(msg) > b.maxPayload {
+		return ErrMessageTooLarge
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, deliver := range b.replicas {
+		deliver(msg)
+	}
+	return nil
+}
+
+func (b *loopBridge) Run(ctx context.Context, deliver func(msg []byte)) {
+	b.mu.Lock()
+	b.replicas = append(b.replicas, deliver)
+	b.mu.Unlock()
+	<-ctx.Done()
+}
+
+func (b *loopBridge) waitForReplicas(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		b.mu.Lock()
+		got := len(b.replicas)
+		b.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("bridge has fewer than %d replicas", n)
+}
+
+func TestBridgeCarriesLargeEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bridge := &loopBridge{maxPayload: 100}
+	events := &memoryEvents{}
+	replicas := make([]*Hub, 2)
+	for i := range replicas {
+		replicas[i] = newTestHub(t)
+		replicas[i].Bridge = bridge
+		replicas[i].Events = events
+		go replicas[i].RunBridge(ctx)
+	}
+	bridge.waitForReplicas(t, 2)
+	local := newTestClient(replicas[0], 1)
+	remote := newTestClient(replicas[1], 1)
+
+	big := strings.Repeat("x", 10*bridge.maxPayload)
+	if err := replicas[0].Broadcast(&Event{UserID: 1, Type: "task_created", TaskID: 5, Data: big}); err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+
+	for _, r := range []struct {
+		name string
+		hub  *Hub
+		c    *Client
+	}{
+		{"local", replicas[0], local},
+		{"remote", replicas[1], remote},
+	} {
+		got := received(t, r.hub, r.c)
+		if len(got) != 1 || got[0]["type"] != "task_created" || got[0]["data"] != big || got[0]["id"] != 1.0 {
+			t.Errorf("%s client got %v, want the large task_created event", r.name, types(got))
+		}
+	}
+}
+
+func TestBridgeWithoutStoreSendsEventsInFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bridge := &loopBridge{maxPayload: 1000}
+	replicas := []*Hub{newTestHub(t), newTestHub(t)}
+	for _, h := range replicas {
+		h.Bridge = bridge
+		go h.RunBridge(ctx)
+	}
+	bridge.waitForReplicas(t, 2)
+	remote := newTestClient(replicas[1], 1)
+
+	replicas[0].Broadcast(&Event{UserID: 1, Type: "task_updated", TaskID: 5, Data: map[string]int{"task_id": 5}})
+	got := received(t, replicas[1], remote)
+	if len(got) != 1 || fmt.Sprint(got[0]["data"]) != "map[task_id:5]" {
+		t.Errorf("remote client got %v, want task_updated with its data", got)
+	}
+}
@@ -0,0 +1,61 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"taskqueue/internal/database"
+	"taskqueue/pkg/logger"
+)
+
+// listenRetryDelay is how long listeners wait before reconnecting.
+const listenRetryDelay = 5 * time.Second
+
+// TaskStatusListener forwards task status changes made anywhere, including
+// by workers writing to Postgres directly, to the task owner's clients as
+// task_updated messages. Every replica runs one and receives every change,
+// so it delivers to local clients only, bypassing the hub's bridge.
+type TaskStatusListener struct {
+	DB  *pgxpool.Pool
+	Hub *Hub
+}
+
+// Run forwards status changes until ctx is cancelled, reconnecting after
+// connection errors.
+func (l *TaskStatusListener) Run(ctx context.Context) {
+	retryListen(ctx, "task status listener", func(ctx context.Context) error {
+		return database.ListenTaskStatus(ctx, l.DB, func(e *database.TaskStatusEvent) {
+			data := *e
+			data.EventID = 0
+			l.Hub.SendLocal(&Event{
+				ID:         e.EventID,
+				UserID:     e.UserID,
+				Type:       "task_updated",
+				TaskID:     e.TaskID,
+				WorkflowID: e.WorkflowID,
+				TaskType:   e.Type,
+				Data:       &data,
+			})
+		})
+	})
+}
+
+// retryListen runs listen until ctx is cancelled, logging its errors and
+// restarting it after listenRetryDelay.
+func retryListen(ctx context.Context, name string, listen func(ctx context.Context) error) {
+	for {
+		err := listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Error(name+":", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetryDelay):
+		}
+	}
+}
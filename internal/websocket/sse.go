@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sseHeartbeat is how often an idle event stream sends a comment, so
+// proxies do not time the connection out.
+const sseHeartbeat = 15 * time.Second
+
+// sseRetry is the reconnection delay suggested to EventSource clients.
+const sseRetry = 5 * time.Second
+
+// ServeEvents streams the events of userID to w as Server-Sent Events, until
+// the request ends. Each event is named by its message type, carries the
+// event ID when it has one, and has the same JSON data as a WebSocket
+// message.
+//
+// Streams can be limited like WebSocket subscriptions with task_id,
+// workflow_id and task_type query parameters, which may repeat. A
+// Last-Event-ID header, or last_event_id query parameter, replays the events
+// missed since that ID first.
+func (h *Hub) ServeEvents(w http.ResponseWriter, r *http.Request, userID int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	client := NewClient(h, nil, userID)
+	if err := subscribeFromQuery(client, r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	flusher.Flush()
+
+	h.Register(client)
+	defer h.Unregister(client)
+
+	if id, err := strconv.ParseInt(lastEventID, 10, 64); err == nil && id > 0 {
+		h.resume(client, id)
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case message, ok := <-client.send:
+			if !ok {
+				// The hub dropped the client.
+				return
+			}
+			if err := writeEvent(w, message); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes a hub message as a Server-Sent Event.
+func writeEvent(w http.ResponseWriter, message []byte) error {
+	var m struct {
+		ID   int64  `json:"id"`
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(message, &m); err != nil {
+		return err
+	}
+	if m.ID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", m.ID); err != nil {
+			return err
+		}
+	}
+	// Hub messages are single-line JSON.
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", m.Type, message)
+	return err
+}
+
+// subscribeFromQuery applies the subscription query parameters of r.
+func subscribeFromQuery(client *Client, r *http.Request) error {
+	query := r.URL.Query()
+	var requests []clientRequest
+	for _, v := range query["task_id"] {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			return fmt.Errorf("invalid task_id %q", v)
+		}
+		requests = append(requests, clientRequest{Action: "subscribe", TaskID: id})
+	}
+	for _, v := range query["workflow_id"] {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			return fmt.Errorf("invalid workflow_id %q", v)
+		}
+		requests = append(requests, clientRequest{Action: "subscribe", WorkflowID: id})
+	}
+	for _, v := range query["task_type"] {
+		if v == "" {
+			return fmt.Errorf("invalid task_type %q", v)
+		}
+		requests = append(requests, clientRequest{Action: "subscribe", TaskType: v})
+	}
+	for i := range requests {
+		client.subs.handle(&requests[i])
+	}
+	return nil
+}
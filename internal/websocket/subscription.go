@@ -0,0 +1,140 @@
+package websocket
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// Event is a message for one user's clients. ID is assigned when the event
+// is recorded. TaskID, WorkflowID and TaskType describe what the event
+// concerns, for routing to subscriptions; zero values match no subscription.
+type Event struct {
+	ID         int64       `json:"id,omitempty"`
+	UserID     int64       `json:"user_id"`
+	Type       string      `json:"type"`
+	TaskID     int64       `json:"task_id,omitempty"`
+	WorkflowID int64       `json:"workflow_id,omitempty"`
+	TaskType   string      `json:"task_type,omitempty"`
+	Data       interface{} `json:"data"`
+}
+
+// clientRequest is a message sent by a client:
+//
+//	{"action": "subscribe", "task_id": 42}
+//	{"action": "subscribe", "workflow_id": 7}
+//	{"action": "subscribe", "task_type": "email"}
+//	{"action": "unsubscribe", "task_id": 42}
+//	{"action": "unsubscribe_all"}
+//	{"action": "resume", "last_event_id": 1234}
+//
+// A subscribe or unsubscribe names exactly one target.
+type clientRequest struct {
+	Action      string `json:"action"`
+	TaskID      int64  `json:"task_id,omitempty"`
+	WorkflowID  int64  `json:"workflow_id,omitempty"`
+	TaskType    string `json:"task_type,omitempty"`
+	LastEventID int64  `json:"last_event_id,omitempty"`
+}
+
+// errBadRequest is returned for a client request that is not understood.
+var errBadRequest = errors.New(`expected {"action": "subscribe"|"unsubscribe", ` +
+	`with one of "task_id", "workflow_id" or "task_type"}, {"action": "unsubscribe_all"} ` +
+	`or {"action": "resume", "last_event_id": N}`)
+
+// subscriptions is the set of tasks, workflows and task types a client
+// follows. A client without subscriptions receives all of its user's events.
+type subscriptions struct {
+	mu        sync.Mutex
+	tasks     map[int64]bool
+	workflows map[int64]bool
+	types     map[string]bool
+}
+
+// matches reports whether e should be sent to the client.
+func (s *subscriptions) matches(e *Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.tasks) == 0 && len(s.workflows) == 0 && len(s.types) == 0 {
+		return true
+	}
+	return (e.TaskID != 0 && s.tasks[e.TaskID]) ||
+		(e.WorkflowID != 0 && s.workflows[e.WorkflowID]) ||
+		(e.TaskType != "" && s.types[e.TaskType])
+}
+
+// handle applies a subscription request and returns the reply to send.
+func (s *subscriptions) handle(req *clientRequest) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Action {
+	case "unsubscribe_all":
+		s.tasks, s.workflows, s.types = nil, nil, nil
+	case "subscribe", "unsubscribe":
+		targets := 0
+		for _, set := range []bool{req.TaskID > 0, req.WorkflowID > 0, req.TaskType != ""} {
+			if set {
+				targets++
+			}
+		}
+		if targets != 1 {
+			return replyError(errBadRequest)
+		}
+		on := req.Action == "subscribe"
+		switch {
+		case req.TaskID > 0:
+			s.tasks = toggle(s.tasks, req.TaskID, on)
+		case req.WorkflowID > 0:
+			s.workflows = toggle(s.workflows, req.WorkflowID, on)
+		default:
+			s.types = toggle(s.types, req.TaskType, on)
+		}
+	default:
+		return replyError(errBadRequest)
+	}
+
+	replyType := "unsubscribed"
+	if req.Action == "subscribe" {
+		replyType = "subscribed"
+	}
+	reply, _ := encodeMessage(0, replyType, s.list())
+	return reply
+}
+
+// list returns the current subscriptions; s.mu must be held.
+func (s *subscriptions) list() map[string]interface{} {
+	return map[string]interface{}{
+		"task_ids":     keys(s.tasks),
+		"workflow_ids": keys(s.workflows),
+		"task_types":   keys(s.types),
+	}
+}
+
+func toggle[K cmp.Ordered](set map[K]bool, key K, on bool) map[K]bool {
+	if !on {
+		delete(set, key)
+		return set
+	}
+	if set == nil {
+		set = make(map[K]bool)
+	}
+	set[key] = true
+	return set
+}
+
+func keys[K cmp.Ordered](set map[K]bool) []K {
+	list := make([]K, 0, len(set))
+	for k := range set {
+		list = append(list, k)
+	}
+	slices.Sort(list)
+	return list
+}
+
+func replyError(err error) []byte {
+	reply, _ := encodeMessage(0, "error", map[string]string{"error": err.Error()})
+	return reply
+}
@@ -0,0 +1,236 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"taskqueue/pkg/logger"
+)
+
+// RegisterBuiltins registers handlers for the task types offered by the
+// dashboard. They mirror the Python and Node.js example workers.
+func RegisterBuiltins(r *Registry) {
+	r.Register("email", handleEmail)
+	r.Register("data", handleData)
+	r.Register("file", handleFile)
+	r.Register("api", handleAPI)
+	r.Register("script", handleScript)
+	r.Register("report", handleReport)
+}
+
+// decodePayload unmarshals a task payload, treating an empty payload as {}.
+func decodePayload(task *Task, v interface{}) error {
+	if len(task.Payload) == 0 || string(task.Payload) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(task.Payload, v); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	return nil
+}
+
+// sleep simulates work, returning early if ctx is cancelled.
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func handleEmail(ctx context.Context, task *Task) (interface{}, error) {
+	var p struct {
+		Recipient string `json:"recipient"`
+		Subject   string `json:"subject"`
+	}
+	if err := decodePayload(task, &p); err != nil {
+		return nil, err
+	}
+
+	logger.Info("sending email to", p.Recipient+":", p.Subject)
+	if err := sleep(ctx, 2*time.Second); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"status":    "sent",
+		"recipient": p.Recipient,
+		"timestamp": time.Now().Unix(),
+	}, nil
+}
+
+func handleData(ctx context.Context, task *Task) (interface{}, error) {
+	var p struct {
+		Operation string    `json:"operation"`
+		Data      []float64 `json:"data"`
+	}
+	if err := decodePayload(task, &p); err != nil {
+		return nil, err
+	}
+
+	logger.Info("processing data operation:", p.Operation)
+
+	var sum float64
+	for _, v := range p.Data {
+		sum += v
+	}
+	var result interface{}
+	switch p.Operation {
+	case "sum":
+		result = sum
+	case "average":
+		if len(p.Data) > 0 {
+			result = sum / float64(len(p.Data))
+		} else {
+			result = 0
+		}
+	case "count":
+		result = len(p.Data)
+	default:
+		result = p.Data
+	}
+
+	if err := sleep(ctx, time.Second); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"operation":       p.Operation,
+		"result":          result,
+		"items_processed": len(p.Data),
+	}, nil
+}
+
+func handleFile(ctx context.Context, task *Task) (interface{}, error) {
+	var p struct {
+		Operation string `json:"operation"`
+		FilePath  string `json:"file_path"`
+	}
+	if err := decodePayload(task, &p); err != nil {
+		return nil, err
+	}
+
+	logger.Info("processing file operation:", p.Operation, "on", p.FilePath)
+	if err := sleep(ctx, 3*time.Second); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"operation":  p.Operation,
+		"file_path":  p.FilePath,
+		"status":     "completed",
+		"size_bytes": 1024, // Mock value
+	}, nil
+}
+
+func handleAPI(ctx context.Context, task *Task) (interface{}, error) {
+	var p struct {
+		URL     string            `json:"url"`
+		Method  string            `json:"method"`
+		Headers map[string]string `json:"headers"`
+		Data    json.RawMessage   `json:"data"`
+	}
+	if err := decodePayload(task, &p); err != nil {
+		return nil, err
+	}
+	if p.Method == "" {
+		p.Method = http.MethodGet
+	}
+
+	logger.Info("making API call:", p.Method, p.URL)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var body io.Reader
+	if len(p.Data) > 0 {
+		body = bytes.NewReader(p.Data)
+	}
+	req, err := http.NewRequestWithContext(ctx, p.Method, p.URL, body)
+	if err != nil {
+		return map[string]interface{}{"error": err.Error(), "status": "failed"}, nil
+	}
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return map[string]interface{}{"error": err.Error(), "status": "failed"}, nil
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return map[string]interface{}{"error": err.Error(), "status": "failed"}, nil
+	}
+
+	var response interface{} = string(data)
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Valid(data) {
+		response = json.RawMessage(data)
+	}
+
+	headers := make(map[string]string, len(resp.Header))
+	for k := range resp.Header {
+		headers[k] = resp.Header.Get(k)
+	}
+
+	return map[string]interface{}{
+		"status_code": resp.StatusCode,
+		"response":    response,
+		"headers":     headers,
+	}, nil
+}
+
+func handleScript(ctx context.Context, task *Task) (interface{}, error) {
+	var p struct {
+		ScriptName string        `json:"script_name"`
+		Args       []interface{} `json:"args"`
+	}
+	if err := decodePayload(task, &p); err != nil {
+		return nil, err
+	}
+
+	logger.Info("executing script:", p.ScriptName, "with args:", p.Args)
+	if err := sleep(ctx, 5*time.Second); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"script":    p.ScriptName,
+		"args":      p.Args,
+		"exit_code": 0,
+		"output":    "Script executed successfully",
+	}, nil
+}
+
+func handleReport(ctx context.Context, task *Task) (interface{}, error) {
+	var p struct {
+		ReportType string `json:"report_type"`
+	}
+	if err := decodePayload(task, &p); err != nil {
+		return nil, err
+	}
+
+	logger.Info("generating report:", p.ReportType)
+	if err := sleep(ctx, 10*time.Second); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"report_type": p.ReportType,
+		"status":      "generated",
+		"file_url":    fmt.Sprintf("/reports/%s_%d.pdf", p.ReportType, time.Now().Unix()),
+		"pages":       42,
+	}, nil
+}
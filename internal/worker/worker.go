@@ -0,0 +1,458 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"taskqueue/internal/database"
+	"taskqueue/internal/models"
+	"taskqueue/internal/queue"
+	"taskqueue/pkg/logger"
+)
+
+// pollBackoff is how long a poller waits after a failed receive.
+const pollBackoff = 5 * time.Second
+
+// defaultHeartbeatInterval is how often a worker reports to the registry
+// unless HeartbeatInterval is set.
+const defaultHeartbeatInterval = 10 * time.Second
+
+// visibilityMargin is how long past a task's timeout its message stays
+// hidden from other consumers, leaving time to record the outcome.
+const visibilityMargin = 30 * time.Second
+
+// notQueuedRetry is how soon a message that arrived before its task was
+// marked queued is redelivered.
+const notQueuedRetry = time.Second
+
+// maxVisibility is the longest a message can be kept hidden, the SQS limit.
+const maxVisibility = 12 * time.Hour
+
+// errCancelRequested is the cause of a task's context being cancelled when
+// its owner asks to cancel it.
+var errCancelRequested = errors.New("task cancellation requested")
+
+// Version is recorded in the worker registry. It is set at build time with
+// -ldflags "-X taskqueue/internal/worker.Version=...".
+var Version = "dev"
+
+// Task is the unit of work passed to a Handler.
+type Task struct {
+	ID        int64
+	Type      string
+	Payload   json.RawMessage
+	MessageID string
+}
+
+// Handler processes a task and returns a JSON-encodable result. ctx is
+// cancelled when the task's timeout expires or its owner cancels it; handlers
+// should then return promptly with an error. A handler that returns a result
+// has its task completed even if ctx is done by then.
+type Handler func(ctx context.Context, task *Task) (interface{}, error)
+
+// Registry maps task types to handlers.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewRegistry creates an empty handler registry.
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]Handler)}
+}
+
+// Register sets the handler for a task type, replacing any existing one.
+func (r *Registry) Register(taskType string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[taskType] = h
+}
+
+// Types returns the registered task types in order.
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]string, 0, len(r.handlers))
+	for t := range r.handlers {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// Lookup returns the handler for a task type.
+func (r *Registry) Lookup(taskType string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[taskType]
+	return h, ok
+}
+
+// Worker polls a broker with a fixed number of concurrent pollers and runs
+// registered handlers, recording status changes in its store. It registers
+// itself in the worker registry and sends heartbeats while it runs.
+type Worker struct {
+	ID          string
+	Broker      queue.Broker
+	Store       database.WorkerStore
+	Registry    *Registry
+	Concurrency int
+
+	// HeartbeatInterval is how often the worker reports to the registry.
+	HeartbeatInterval time.Duration
+
+	mu      sync.Mutex
+	running map[string]*runningTask // by message ID
+}
+
+// runningTask is an in-flight task and the function that cancels its
+// context.
+type runningTask struct {
+	id     int64
+	cancel context.CancelCauseFunc
+}
+
+// New creates a worker. Concurrency below one is treated as one.
+func New(id string, broker queue.Broker, store database.WorkerStore, registry *Registry, concurrency int) *Worker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Worker{
+		ID:                id,
+		Broker:            broker,
+		Store:             store,
+		Registry:          registry,
+		Concurrency:       concurrency,
+		HeartbeatInterval: defaultHeartbeatInterval,
+		running:           make(map[string]*runningTask),
+	}
+}
+
+// Run starts the pollers and blocks until ctx is cancelled. Pollers stop
+// receiving as soon as ctx is done, but tasks already in flight run to
+// completion before Run returns.
+func (w *Worker) Run(ctx context.Context) error {
+	logger.Info("worker", w.ID, "starting with", w.Concurrency, "pollers")
+
+	// Heartbeats continue while in-flight tasks finish after ctx is done.
+	heartbeatCtx, stopHeartbeats := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopHeartbeats()
+	w.register(ctx)
+	go w.heartbeat(heartbeatCtx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.poll(ctx)
+		}()
+	}
+	wg.Wait()
+
+	logger.Info("worker", w.ID, "stopped")
+	return nil
+}
+
+func (w *Worker) poll(ctx context.Context) {
+	for ctx.Err() == nil {
+		msgs, err := w.Broker.ReceiveMessages(ctx, 1)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("worker: receive:", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollBackoff):
+			}
+			continue
+		}
+
+		for _, msg := range msgs {
+			// In-flight tasks are not cancelled by shutdown.
+			w.process(context.WithoutCancel(ctx), msg)
+		}
+	}
+}
+
+func (w *Worker) process(ctx context.Context, msg *queue.Message) {
+	if msg.Err != nil {
+		w.deadLetter(ctx, msg, msg.Err)
+		return
+	}
+	body, err := queue.DecodeTaskMessage(msg.Body)
+	if err != nil {
+		w.deadLetter(ctx, msg, err)
+		return
+	}
+
+	task := &Task{
+		ID:        body.TaskID,
+		Type:      body.Type,
+		Payload:   body.Payload,
+		MessageID: msg.ID,
+	}
+	logger.Info("worker: processing task", task.ID, "of type", task.Type)
+
+	if err := w.Store.UpdateTaskProgress(ctx, msg.ID, w.ID, task.ID); err != nil {
+		if errors.Is(err, database.ErrStaleMessage) {
+			// Duplicate delivery or a task that is no longer queued.
+			logger.Info("worker: dropping stale message", msg.ID, "for task", task.ID)
+			w.ack(ctx, msg)
+			return
+		}
+		if errors.Is(err, database.ErrNotQueuedYet) {
+			// The relay has sent the message but not yet committed.
+			logger.Info("worker: task", task.ID, "not queued yet; retrying message", msg.ID)
+			if err := w.Broker.ExtendVisibility(ctx, msg.Receipt, notQueuedRetry); err != nil {
+				logger.Error("worker: extend visibility:", err)
+			}
+			return
+		}
+		// Leave the message on the queue; it will be redelivered.
+		logger.Error("worker: update task progress:", err)
+		return
+	}
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	w.track(msg.ID, task.ID, cancel)
+	defer w.untrack(msg.ID)
+
+	timeout := time.Duration(body.TimeoutSeconds) * time.Second
+	if timeout > 0 {
+		// Keep the message from being redelivered while the task may run.
+		if err := w.Broker.ExtendVisibility(ctx, msg.Receipt, min(timeout+visibilityMargin, maxVisibility)); err != nil {
+			logger.Error("worker: extend visibility:", err)
+		}
+		var cancelTimeout context.CancelFunc
+		runCtx, cancelTimeout = context.WithTimeout(runCtx, timeout)
+		defer cancelTimeout()
+	}
+
+	result, err := w.execute(runCtx, task)
+	if err != nil {
+		// A handler that failed once its context was done is taken to have
+		// been stopped by it; one that succeeded keeps its result.
+		switch {
+		case errors.Is(context.Cause(runCtx), errCancelRequested):
+			w.cancelled(ctx, msg, task)
+			return
+		case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+			w.timeout(ctx, msg, task, timeout)
+			return
+		}
+
+		status, failErr := w.Store.FailTask(ctx, msg.ID, err.Error())
+		switch {
+		case errors.Is(failErr, database.ErrStaleMessage):
+			logger.Info("worker: task", task.ID, "failed but is no longer processing:", err)
+		case failErr != nil:
+			logger.Error("worker: fail task:", failErr)
+			return
+		default:
+			logger.Error("worker: task", task.ID, "failed:", err, "- status", status)
+		}
+		w.ack(ctx, msg)
+		return
+	}
+
+	if err := w.Store.CompleteTask(ctx, msg.ID, result); err != nil {
+		if !errors.Is(err, database.ErrStaleMessage) {
+			logger.Error("worker: complete task:", err)
+			return
+		}
+		logger.Info("worker: task", task.ID, "finished but is no longer processing")
+		w.ack(ctx, msg)
+		return
+	}
+	w.ack(ctx, msg)
+	logger.Info("worker: task", task.ID, "completed")
+}
+
+// timeout ends a task whose handler failed after running past its timeout.
+func (w *Worker) timeout(ctx context.Context, msg *queue.Message, task *Task, timeout time.Duration) {
+	err := w.Store.TimeoutTask(ctx, msg.ID, fmt.Sprintf("task timed out after %s", timeout))
+	switch {
+	case errors.Is(err, database.ErrStaleMessage):
+		logger.Info("worker: task", task.ID, "timed out but is no longer processing")
+	case err != nil:
+		logger.Error("worker: time out task:", err)
+		return
+	default:
+		logger.Error("worker: task", task.ID, "timed out after", timeout)
+	}
+	w.ack(ctx, msg)
+}
+
+// cancelled ends a task whose handler failed after its owner asked to
+// cancel it.
+func (w *Worker) cancelled(ctx context.Context, msg *queue.Message, task *Task) {
+	err := w.Store.CancelRunningTask(ctx, msg.ID)
+	switch {
+	case errors.Is(err, database.ErrStaleMessage):
+		logger.Info("worker: task", task.ID, "cancelled but is no longer processing")
+	case err != nil:
+		logger.Error("worker: cancel task:", err)
+		return
+	default:
+		logger.Info("worker: task", task.ID, "cancelled")
+	}
+	w.ack(ctx, msg)
+}
+
+// register records the worker in the registry. A failure is logged and
+// retried by the next heartbeat.
+func (w *Worker) register(ctx context.Context) {
+	host, _ := os.Hostname()
+	err := w.Store.RegisterWorker(ctx, &models.Worker{
+		ID:        w.ID,
+		Language:  "go",
+		Host:      host,
+		TaskTypes: w.Registry.Types(),
+		Version:   Version,
+	})
+	if err != nil {
+		logger.Error("worker: register:", err)
+	}
+}
+
+// heartbeat reports to the registry, and for each in-flight task, every
+// HeartbeatInterval until ctx is cancelled, registering again if the
+// registration has been lost. Task heartbeats keep the reaper off tasks that
+// are still running, and cancel the context of tasks whose owner has asked
+// to cancel them.
+func (w *Worker) heartbeat(ctx context.Context) {
+	interval := w.HeartbeatInterval
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := w.Store.WorkerHeartbeat(ctx, w.ID, w.currentTask())
+		if errors.Is(err, database.ErrWorkerNotFound) {
+			w.register(ctx)
+		} else if err != nil && ctx.Err() == nil {
+			logger.Error("worker: heartbeat:", err)
+		}
+
+		for _, messageID := range w.runningMessages() {
+			t, err := w.Store.TaskHeartbeat(ctx, messageID)
+			if err != nil {
+				if !errors.Is(err, database.ErrStaleMessage) && ctx.Err() == nil {
+					logger.Error("worker: task heartbeat:", err)
+				}
+				continue
+			}
+			if t.CancelRequested {
+				w.cancel(messageID)
+			}
+		}
+	}
+}
+
+func (w *Worker) track(messageID string, taskID int64, cancel context.CancelCauseFunc) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running[messageID] = &runningTask{id: taskID, cancel: cancel}
+}
+
+func (w *Worker) untrack(messageID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.running, messageID)
+}
+
+// cancel cancels the context of the in-flight task carrying messageID.
+func (w *Worker) cancel(messageID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if rt, ok := w.running[messageID]; ok {
+		rt.cancel(errCancelRequested)
+	}
+}
+
+func (w *Worker) runningMessages() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ids := make([]string, 0, len(w.running))
+	for id := range w.running {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// currentTask returns the oldest in-flight task by ID, or zero when idle.
+// The registry holds one current task per worker.
+func (w *Worker) currentTask() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var current int64
+	for _, rt := range w.running {
+		if current == 0 || rt.id < current {
+			current = rt.id
+		}
+	}
+	return current
+}
+
+// execute runs the registered handler and encodes its result, turning a
+// missing handler or a panic into an error.
+func (w *Worker) execute(ctx context.Context, task *Task) (result []byte, err error) {
+	handler, ok := w.Registry.Lookup(task.Type)
+	if !ok {
+		return nil, fmt.Errorf("unknown task type: %s", task.Type)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+
+	out, err := handler(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+// deadLetter records a message that cannot be parsed and removes it from
+// the queue. If recording fails the message is left to be redelivered.
+func (w *Worker) deadLetter(ctx context.Context, msg *queue.Message, cause error) {
+	logger.Error("worker: dead-lettering malformed message", msg.ID+":", cause)
+	err := w.Store.InsertDeadLetter(ctx, &models.DeadLetter{
+		MessageID: msg.ID,
+		Body:      msg.Body,
+		Reason:    "malformed",
+		Error:     cause.Error(),
+		Attempts:  msg.ReceiveCount,
+	})
+	if err != nil {
+		logger.Error("worker: insert dead letter:", err)
+		return
+	}
+	if msg.Receipt != "" {
+		w.ack(ctx, msg)
+	}
+}
+
+func (w *Worker) ack(ctx context.Context, msg *queue.Message) {
+	if err := w.Broker.DeleteMessage(ctx, msg.Receipt); err != nil {
+		logger.Error("worker: delete message:", err)
+	}
+}
@@ -0,0 +1,211 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"taskqueue/internal/database"
+	"taskqueue/internal/models"
+	"taskqueue/internal/queue"
+)
+
+// blockUntilDone returns a handler that, after calling start, waits for its
+// context to be done and then returns result, or the context's error if
+// result is nil.
+func blockUntilDone(start func(ctx context.Context, task *Task), result interface{}) Handler {
+	return func(ctx context.Context, task *Task) (interface{}, error) {
+		start(ctx, task)
+		<-ctx.Done()
+		if result == nil {
+			return nil, ctx.Err()
+		}
+		return result, nil
+	}
+}
+
+func TestWorkerProcess(t *testing.T) {
+	nothing := func(ctx context.Context, task *Task) {}
+	tests := []struct {
+		name        string
+		timeout     int
+		maxAttempts int
+		// handler is given the store so it can act as the task's owner.
+		handler    func(store *database.MemoryStore) Handler
+		status     string
+		result     string
+		deadLetter bool
+	}{
+		{
+			name:        "completed",
+			maxAttempts: 3,
+			handler: func(store *database.MemoryStore) Handler {
+				return func(ctx context.Context, task *Task) (interface{}, error) {
+					return map[string]bool{"ok": true}, nil
+				}
+			},
+			status: "completed",
+			result: `{"ok":true}`,
+		},
+		{
+			name:        "failed with attempts left",
+			maxAttempts: 3,
+			handler: func(store *database.MemoryStore) Handler {
+				return func(ctx context.Context, task *Task) (interface{}, error) {
+					return nil, errors.New("boom")
+				}
+			},
+			status: "retrying",
+		},
+		{
+			name:        "failed",
+			maxAttempts: 1,
+			handler: func(store *database.MemoryStore) Handler {
+				return func(ctx context.Context, task *Task) (interface{}, error) {
+					return nil, errors.New("boom")
+				}
+			},
+			status:     "failed",
+			deadLetter: true,
+		},
+		{
+			name:        "stopped by timeout",
+			timeout:     1,
+			maxAttempts: 3,
+			handler: func(store *database.MemoryStore) Handler {
+				return blockUntilDone(nothing, nil)
+			},
+			status: "timed_out",
+		},
+		{
+			name:        "result after timeout",
+			timeout:     1,
+			maxAttempts: 3,
+			handler: func(store *database.MemoryStore) Handler {
+				return blockUntilDone(nothing, "late")
+			},
+			status: "completed",
+			result: `"late"`,
+		},
+		{
+			name:        "stopped by cancel",
+			maxAttempts: 3,
+			handler: func(store *database.MemoryStore) Handler {
+				return blockUntilDone(func(ctx context.Context, task *Task) {
+					store.CancelTask(ctx, task.ID, 1)
+				}, nil)
+			},
+			status: "cancelled",
+		},
+		{
+			name:        "result after cancel",
+			maxAttempts: 3,
+			handler: func(store *database.MemoryStore) Handler {
+				return blockUntilDone(func(ctx context.Context, task *Task) {
+					store.CancelTask(ctx, task.ID, 1)
+				}, "late")
+			},
+			status: "completed",
+			result: `"late"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			store := database.NewMemoryStore()
+			broker := queue.NewMemory(time.Minute)
+			registry := NewRegistry()
+			registry.Register("test", tt.handler(store))
+			w := New("test-worker", broker, store, registry, 1)
+			w.HeartbeatInterval = 10 * time.Millisecond
+			go w.heartbeat(ctx)
+
+			// A fresh store numbers its first task 1.
+			body, err := (&queue.TaskMessage{TaskID: 1, Type: "test", TimeoutSeconds: tt.timeout}).Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			messageID, err := broker.Enqueue(ctx, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			task := &models.Task{
+				UserID:         1,
+				Type:           "test",
+				Status:         "queued",
+				MessageID:      messageID,
+				MaxAttempts:    tt.maxAttempts,
+				TimeoutSeconds: tt.timeout,
+			}
+			if err := store.CreateTask(ctx, task); err != nil || task.ID != 1 {
+				t.Fatalf("created task %d: %v", task.ID, err)
+			}
+
+			msgs, err := broker.ReceiveMessages(ctx, 1)
+			if err != nil || len(msgs) != 1 {
+				t.Fatalf("received %d messages: %v", len(msgs), err)
+			}
+			w.process(ctx, msgs[0])
+
+			got, err := store.GetTask(ctx, task.ID, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.status {
+				t.Errorf("status = %s, want %s", got.Status, tt.status)
+			}
+			if string(got.Result) != tt.result {
+				t.Errorf("result = %s, want %s", got.Result, tt.result)
+			}
+			if dl := store.DeadLetters(); (len(dl) > 0) != tt.deadLetter {
+				t.Errorf("dead letters = %v", dl)
+			}
+
+			// Every outcome removes the message from the queue.
+			attrs, err := broker.GetQueueAttributes(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attrs[queue.AttrVisible] != "0" || attrs[queue.AttrInFlight] != "0" {
+				t.Errorf("message left on the queue: %v", attrs)
+			}
+		})
+	}
+}
+
+func TestWorkerProcessStaleMessage(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	broker := queue.NewMemory(time.Minute)
+	called := false
+	registry := NewRegistry()
+	registry.Register("test", func(ctx context.Context, task *Task) (interface{}, error) {
+		called = true
+		return nil, nil
+	})
+	w := New("test-worker", broker, store, registry, 1)
+
+	// The task was cancelled while its message was queued.
+	body, _ := (&queue.TaskMessage{TaskID: 1, Type: "test"}).Encode()
+	messageID, _ := broker.Enqueue(ctx, body)
+	store.CreateTask(ctx, &models.Task{UserID: 1, Type: "test", Status: "cancelled", MessageID: messageID})
+
+	msgs, err := broker.ReceiveMessages(ctx, 1)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("received %d messages: %v", len(msgs), err)
+	}
+	w.process(ctx, msgs[0])
+
+	if called {
+		t.Error("handler ran for a stale message")
+	}
+	attrs, _ := broker.GetQueueAttributes(ctx)
+	if attrs[queue.AttrVisible] != "0" || attrs[queue.AttrInFlight] != "0" {
+		t.Errorf("stale message left on the queue: %v", attrs)
+	}
+}
@@ -4,11 +4,13 @@
  * 
  * This worker polls SQS for tasks and processes them based on their type.
  * Supports multiple task types with extensible handler architecture.
+ * Task state is reported to the server through its worker API.
  */
 
 const AWS = require('aws-sdk');
-const { Client } = require('pg');
+const os = require('os');
 const axios = require('axios');
+const { version } = require('./package.json');
 
 // Configure AWS
 AWS.config.update({
@@ -23,13 +25,43 @@ const queueUrl = process.env.AWS_SQS_QUEUE_URL;
 
 // Worker configuration
 const workerId = `nodejs-worker-${process.pid}`;
+const heartbeatInterval = Number(process.env.HEARTBEAT_INTERVAL || 10) * 1000;
 let running = true;
+// Task being processed, if any: { taskId, messageId, controller }, where
+// aborting controller stops waiting for the task
+let current = null;
 
-// Initialize database client
-const dbClient = new Client({
-    connectionString: process.env.DATABASE_URL
+// Seconds past a task's timeout its message stays hidden, and the most SQS
+// allows
+const visibilityMargin = 30;
+const maxVisibility = 12 * 60 * 60;
+
+// Seconds before a message that arrived ahead of its task being queued is
+// redelivered
+const notQueuedRetry = 1;
+
+// Task state is reported to the server, never written directly
+const api = axios.create({
+    baseURL: (process.env.API_URL || '').replace(/\/$/, ''),
+    headers: { Authorization: `Bearer ${process.env.WORKER_TOKEN}` },
+    timeout: 10000
 });
 
+// Raised when the server no longer expects the message's task
+class StaleMessageError extends Error {}
+
+// Raised when a message arrives before its task is marked queued
+class NotQueuedYetError extends Error {}
+
+// Raised when the server does not know the worker
+class NotRegisteredError extends Error {}
+
+// Raised when a task runs past its timeout
+class TimeoutError extends Error {}
+
+// Raised when a task's owner cancels it
+class CancelledError extends Error {}
+
 // Task handlers mapping
 const handlers = {
     email: handleEmailTask,
@@ -53,7 +85,6 @@ process.on('SIGTERM', shutdown);
 async function shutdown() {
     logger.info(`Worker ${workerId} shutting down...`);
     running = false;
-    await dbClient.end();
     process.exit(0);
 }
 
@@ -61,9 +92,8 @@ async function shutdown() {
 async function startWorker() {
     logger.info(`Worker ${workerId} starting...`);
     
-    // Connect to database
-    await dbClient.connect();
-    logger.info('Connected to database');
+    await register();
+    setInterval(sendHeartbeats, heartbeatInterval);
     
     while (running) {
         try {
@@ -72,7 +102,8 @@ async function startWorker() {
                 QueueUrl: queueUrl,
                 MaxNumberOfMessages: 1,
                 WaitTimeSeconds: 20, // Long polling
-                MessageAttributeNames: ['All']
+                MessageAttributeNames: ['All'],
+                AttributeNames: ['ApproximateReceiveCount']
             };
             
             const response = await sqs.receiveMessage(params).promise();
@@ -91,74 +122,213 @@ async function startWorker() {
 
 // Process a single message
 async function processMessage(message) {
+    const messageId = message.MessageId;
+    let body;
     try {
-        // Parse message body
-        const body = JSON.parse(message.Body);
-        const taskId = body.task_id;
-        const taskType = body.type;
-        const payload = body.payload || {};
-        
-        logger.info(`Processing task ${taskId} of type ${taskType}`);
-        
-        // Update task status to processing
-        await updateTaskStatus(message.MessageId, 'processing');
+        body = JSON.parse(message.Body);
+        if (body === null || typeof body !== 'object' || Array.isArray(body)) {
+            throw new Error('message body is not a JSON object');
+        }
+    } catch (error) {
+        await deadLetter(message, error);
+        return;
+    }
+    
+    let taskId, taskType, payload, timeout;
+    try {
+        taskId = body.task_id;
+        taskType = body.type;
+        payload = body.payload || {};
+        timeout = body.timeout_seconds || 0;
         
+        // Claim the task; a stale message is a duplicate delivery or a task
+        // that is no longer queued
+        await callback(messageId, 'start', { worker_id: workerId, task_id: taskId });
+    } catch (error) {
+        if (error instanceof StaleMessageError) {
+            logger.info(`Dropping stale message ${messageId}`);
+            await deleteMessage(message);
+        } else if (error instanceof NotQueuedYetError) {
+            // The server has sent the message but not yet recorded it
+            logger.info(`Task ${taskId} not queued yet; retrying message ${messageId}`);
+            await extendVisibility(message, notQueuedRetry);
+        } else {
+            // Leave the message on the queue; it will be redelivered
+            logger.error(`Error starting message ${messageId}: ${error.message}`);
+        }
+        return;
+    }
+    
+    logger.info(`Processing task ${taskId} of type ${taskType}`);
+    
+    if (timeout) {
+        // Keep the message from being redelivered while the task may run
+        await extendVisibility(message, timeout + visibilityMargin);
+    }
+    
+    const controller = new AbortController();
+    current = { taskId, messageId, controller };
+    let result;
+    let timer;
+    try {
         // Get handler for task type
         const handler = handlers[taskType];
         if (!handler) {
             throw new Error(`Unknown task type: ${taskType}`);
         }
         
-        // Execute task handler
-        const result = await handler(payload);
-        
-        // Mark task as completed
-        await completeTask(message.MessageId, result);
-        
-        // Delete message from queue
-        await sqs.deleteMessage({
-            QueueUrl: queueUrl,
-            ReceiptHandle: message.ReceiptHandle
-        }).promise();
-        
-        logger.info(`Task ${taskId} completed successfully`);
-        
+        // Execute task handler, giving up on it once the timeout expires
+        // or the owner cancels it
+        const stopped = new Promise((resolve, reject) => {
+            controller.signal.addEventListener('abort', () => reject(controller.signal.reason));
+        });
+        stopped.catch(() => {});
+        if (timeout) {
+            timer = setTimeout(() => controller.abort(new TimeoutError(`task timed out after ${timeout}s`)), timeout * 1000);
+        }
+        result = await Promise.race([handler(payload, controller.signal), stopped]);
     } catch (error) {
-        logger.error(`Error processing message: ${error.message}`);
-        await failTask(message.MessageId, error.message);
+        current = null;
+        if (error instanceof TimeoutError) {
+            logger.error(`Task ${taskId} timed out after ${timeout}s`);
+            await report(message, 'timeout', { error: error.message });
+            return;
+        }
+        if (error instanceof CancelledError) {
+            logger.info(`Task ${taskId} cancelled`);
+            await report(message, 'cancel', {});
+            return;
+        }
+        logger.error(`Task ${taskId} failed: ${error.message}`);
+        await report(message, 'fail', { error: error.message });
+        return;
+    } finally {
+        clearTimeout(timer);
     }
+    current = null;
+    
+    await report(message, 'complete', { result: result });
 }
 
-// Database operations
-async function updateTaskStatus(messageId, status) {
-    const query = `
-        UPDATE tasks 
-        SET status = $1, worker_id = $2, started_at = CURRENT_TIMESTAMP
-        WHERE message_id = $3
-    `;
-    await dbClient.query(query, [status, workerId, messageId]);
+// Worker API operations
+async function post(path, body) {
+    try {
+        const response = await api.post(`/api/worker/${path}`, body);
+        return response.data;
+    } catch (error) {
+        if (error.response && error.response.status === 409) {
+            throw new StaleMessageError(error.response.data.error);
+        }
+        if (error.response && error.response.status === 425) {
+            throw new NotQueuedYetError(error.response.data.error);
+        }
+        if (error.response && error.response.status === 404) {
+            throw new NotRegisteredError(error.response.data.error);
+        }
+        throw error;
+    }
 }
 
-async function completeTask(messageId, result) {
-    const query = `
-        UPDATE tasks 
-        SET status = 'completed', 
-            result = $1,
-            completed_at = CURRENT_TIMESTAMP
-        WHERE message_id = $2
-    `;
-    await dbClient.query(query, [JSON.stringify(result), messageId]);
+async function callback(messageId, action, body) {
+    return post(`tasks/${encodeURIComponent(messageId)}/${action}`, body);
+}
+
+// Record the worker in the server's registry
+async function register() {
+    try {
+        await post('register', {
+            worker_id: workerId,
+            language: 'nodejs',
+            host: os.hostname(),
+            task_types: Object.keys(handlers).sort(),
+            version: version
+        });
+    } catch (error) {
+        logger.error(`Error registering worker: ${error.message}`);
+    }
+}
+
+// Tell the server the worker, and the task it runs, are alive
+async function sendHeartbeats() {
+    const task = current;
+    try {
+        await post('heartbeat', {
+            worker_id: workerId,
+            current_task_id: task ? task.taskId : 0
+        });
+    } catch (error) {
+        if (error instanceof NotRegisteredError) {
+            await register();
+        } else {
+            logger.error(`Worker heartbeat failed: ${error.message}`);
+        }
+    }
+    
+    if (task) {
+        try {
+            const response = await callback(task.messageId, 'heartbeat', {});
+            if (response.cancel_requested) {
+                task.controller.abort(new CancelledError('task cancelled by owner'));
+            }
+        } catch (error) {
+            if (!(error instanceof StaleMessageError)) {
+                logger.error(`Heartbeat for message ${task.messageId} failed: ${error.message}`);
+            }
+        }
+    }
+}
+
+// Report a task outcome and delete its message once the server has it. If
+// the report fails the message is redelivered.
+async function report(message, action, body) {
+    try {
+        const response = await callback(message.MessageId, action, body);
+        logger.info(`Message ${message.MessageId}: task is now ${response.status}`);
+    } catch (error) {
+        if (!(error instanceof StaleMessageError)) {
+            logger.error(`Error reporting ${action} for message ${message.MessageId}: ${error.message}`);
+            return;
+        }
+        logger.info(`Task for message ${message.MessageId} is no longer processing`);
+    }
+    await deleteMessage(message);
+}
+
+// Record a message that cannot be parsed and delete it. If recording fails
+// the message is redelivered.
+async function deadLetter(message, error) {
+    logger.error(`Dead-lettering malformed message ${message.MessageId}: ${error.message}`);
+    try {
+        await callback(message.MessageId, 'dead-letter', {
+            body: message.Body,
+            error: error.message,
+            attempts: Number((message.Attributes || {}).ApproximateReceiveCount || 0)
+        });
+    } catch (err) {
+        logger.error(`Error dead-lettering message ${message.MessageId}: ${err.message}`);
+        return;
+    }
+    await deleteMessage(message);
+}
+
+// Hide a received message from other consumers for seconds
+async function extendVisibility(message, seconds) {
+    try {
+        await sqs.changeMessageVisibility({
+            QueueUrl: queueUrl,
+            ReceiptHandle: message.ReceiptHandle,
+            VisibilityTimeout: Math.min(seconds, maxVisibility)
+        }).promise();
+    } catch (error) {
+        logger.error(`Error extending visibility of message ${message.MessageId}: ${error.message}`);
+    }
 }
 
-async function failTask(messageId, error) {
-    const query = `
-        UPDATE tasks 
-        SET status = 'failed', 
-            error_message = $1,
-            completed_at = CURRENT_TIMESTAMP
-        WHERE message_id = $2
-    `;
-    await dbClient.query(query, [error, messageId]);
+async function deleteMessage(message) {
+    await sqs.deleteMessage({
+        QueueUrl: queueUrl,
+        ReceiptHandle: message.ReceiptHandle
+    }).promise();
 }
 
 // Task handlers
@@ -291,7 +461,8 @@ const requiredEnv = [
     'AWS_ACCESS_KEY_ID',
     'AWS_SECRET_ACCESS_KEY',
     'AWS_SQS_QUEUE_URL',
-    'DATABASE_URL'
+    'API_URL',
+    'WORKER_TOKEN'
 ];
 
 const missing = requiredEnv.filter(varName => !process.env[varName]);
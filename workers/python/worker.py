@@ -4,20 +4,33 @@ Python Worker for Task Queue System
 
 This worker polls SQS for tasks and processes them based on their type.
 Supports multiple task types with extensible handler architecture.
+Task state is reported to the server through its worker API.
 """
 
 import json
 import logging
 import os
 import signal
+import socket
 import sys
+import threading
 import time
 from typing import Dict, Any, Callable
 import boto3
-import psycopg2
-from psycopg2.extras import RealDictCursor
 import requests
 
+# Reported to the server when the worker registers
+VERSION = '1.0.0'
+
+# Seconds past a task's timeout its message stays hidden, and the most SQS
+# allows
+VISIBILITY_MARGIN = 30
+MAX_VISIBILITY = 12 * 60 * 60
+
+# Seconds before a message that arrived ahead of its task being queued is
+# redelivered
+NOT_QUEUED_RETRY = 1
+
 # Configure logging
 logging.basicConfig(
     level=logging.INFO,
@@ -26,12 +39,58 @@ logging.basicConfig(
 logger = logging.getLogger(__name__)
 
 
+class StaleMessage(Exception):
+    """Raised when the server no longer expects the message's task"""
+
+
+class NotQueuedYet(Exception):
+    """Raised when a message arrives before its task is marked queued"""
+
+
+class NotRegistered(Exception):
+    """Raised when the server does not know the worker"""
+
+
+class TaskAPI:
+    """Client for the server's worker callback API"""
+    
+    def __init__(self, base_url: str, token: str):
+        self.base_url = base_url.rstrip('/')
+        self.session = requests.Session()
+        self.session.headers['Authorization'] = f'Bearer {token}'
+    
+    def post(self, path: str, body: Dict[str, Any]) -> Dict[str, Any]:
+        """POST a callback to the worker API"""
+        response = self.session.post(
+            f'{self.base_url}/api/worker/{path}',
+            json=body,
+            timeout=10
+        )
+        if response.status_code == 409:
+            raise StaleMessage(response.json().get('error'))
+        if response.status_code == 425:
+            raise NotQueuedYet(response.json().get('error'))
+        if response.status_code == 404:
+            raise NotRegistered(response.json().get('error'))
+        response.raise_for_status()
+        return response.json()
+    
+    def task(self, message_id: str, action: str, body: Dict[str, Any]) -> Dict[str, Any]:
+        """POST a callback for the task carrying message_id"""
+        return self.post(f'tasks/{message_id}/{action}', body)
+
+
 class TaskWorker:
     """Main worker class that processes tasks from SQS"""
     
     def __init__(self):
         self.running = True
         self.worker_id = f"python-worker-{os.getpid()}"
+        self.heartbeat_interval = float(os.getenv('HEARTBEAT_INTERVAL', '10'))
+        # (task_id, message_id) of the task being processed, if any
+        self.current = None
+        # Message IDs of tasks whose owner asked to cancel them
+        self.cancel_requested = set()
         
         # Initialize AWS SQS client
         self.sqs = boto3.client(
@@ -42,9 +101,8 @@ class TaskWorker:
         )
         self.queue_url = os.getenv('AWS_SQS_QUEUE_URL')
         
-        # Initialize database connection
-        self.db_conn = psycopg2.connect(os.getenv('DATABASE_URL'))
-        self.db_conn.autocommit = True
+        # Task state is reported to the server, never written directly
+        self.api = TaskAPI(os.getenv('API_URL'), os.getenv('WORKER_TOKEN'))
         
         # Task handlers mapping
         self.handlers: Dict[str, Callable] = {
@@ -66,14 +124,15 @@ class TaskWorker:
         """Handle shutdown signals gracefully"""
         logger.info(f"Worker {self.worker_id} shutting down...")
         self.running = False
-        if self.db_conn:
-            self.db_conn.close()
         sys.exit(0)
     
     def run(self):
         """Main worker loop"""
         logger.info(f"Worker {self.worker_id} starting...")
         
+        self.register()
+        threading.Thread(target=self.send_heartbeats, daemon=True).start()
+        
         while self.running:
             try:
                 # Poll SQS for messages
@@ -81,7 +140,8 @@ class TaskWorker:
                     QueueUrl=self.queue_url,
                     MaxNumberOfMessages=1,
                     WaitTimeSeconds=20,  # Long polling
-                    MessageAttributeNames=['All']
+                    MessageAttributeNames=['All'],
+                    AttributeNames=['ApproximateReceiveCount']
                 )
                 
                 messages = response.get('Messages', [])
@@ -95,18 +155,48 @@ class TaskWorker:
     
     def process_message(self, message: Dict[str, Any]):
         """Process a single message from SQS"""
+        message_id = message['MessageId']
         try:
-            # Parse message body
             body = json.loads(message['Body'])
+            if not isinstance(body, dict):
+                raise ValueError('message body is not a JSON object')
+        except ValueError as e:
+            self.dead_letter(message, e)
+            return
+        
+        try:
             task_id = body.get('task_id')
             task_type = body.get('type')
-            payload = body.get('payload', {})
-            
-            logger.info(f"Processing task {task_id} of type {task_type}")
-            
-            # Update task status to processing
-            self.update_task_status(message['MessageId'], 'processing')
+            payload = body.get('payload') or {}
+            timeout = body.get('timeout_seconds') or 0
             
+            # Claim the task; a stale message is a duplicate delivery or a
+            # task that is no longer queued
+            self.api.task(message_id, 'start', {'worker_id': self.worker_id, 'task_id': task_id})
+        except StaleMessage:
+            logger.info(f"Dropping stale message {message_id}")
+            self.delete_message(message)
+            return
+        except NotQueuedYet:
+            # The server has sent the message but not yet recorded it
+            logger.info(f"Task {task_id} not queued yet; retrying message {message_id}")
+            self.extend_visibility(message, NOT_QUEUED_RETRY)
+            return
+        except Exception as e:
+            # Leave the message on the queue; it will be redelivered
+            logger.error(f"Error starting message {message_id}: {e}")
+            return
+        
+        logger.info(f"Processing task {task_id} of type {task_type}")
+        
+        if timeout:
+            # Keep the message from being redelivered while the task may run
+            self.extend_visibility(message, timeout + VISIBILITY_MARGIN)
+        
+        self.current = (task_id, message_id)
+        started = time.monotonic()
+        result, error = None, None
+        try:
             # Get handler for task type
             handler = self.handlers.get(task_type)
             if not handler:
@@ -114,52 +204,110 @@ class TaskWorker:
             
             # Execute task handler
             result = handler(payload)
+        except Exception as e:
+            error = e
+        self.current = None
+        
+        # Handlers cannot be interrupted, so a task that was cancelled or
+        # overran its timeout is reported once it returns, whatever the outcome
+        cancelled = message_id in self.cancel_requested
+        self.cancel_requested.discard(message_id)
+        if cancelled:
+            logger.info(f"Task {task_id} cancelled")
+            self.report(message, 'cancel', {})
+        elif timeout and time.monotonic() - started > timeout:
+            logger.error(f"Task {task_id} timed out after {timeout}s")
+            self.report(message, 'timeout', {'error': f"task timed out after {timeout}s"})
+        elif error:
+            logger.error(f"Task {task_id} failed: {error}")
+            self.report(message, 'fail', {'error': str(error)})
+        else:
+            self.report(message, 'complete', {'result': result})
+    
+    def register(self):
+        """Record the worker in the server's registry"""
+        try:
+            self.api.post('register', {
+                'worker_id': self.worker_id,
+                'language': 'python',
+                'host': socket.gethostname(),
+                'task_types': sorted(self.handlers),
+                'version': VERSION,
+            })
+        except Exception as e:
+            logger.error(f"Error registering worker: {e}")
+    
+    def send_heartbeats(self):
+        """Tell the server the worker, and the task it runs, are alive"""
+        while self.running:
+            time.sleep(self.heartbeat_interval)
+            current = self.current
+            try:
+                self.api.post('heartbeat', {
+                    'worker_id': self.worker_id,
+                    'current_task_id': current[0] if current else 0,
+                })
+            except NotRegistered:
+                self.register()
+            except Exception as e:
+                logger.error(f"Worker heartbeat failed: {e}")
             
-            # Mark task as completed
-            self.complete_task(message['MessageId'], result)
-            
-            # Delete message from queue
-            self.sqs.delete_message(
-                QueueUrl=self.queue_url,
-                ReceiptHandle=message['ReceiptHandle']
-            )
-            
-            logger.info(f"Task {task_id} completed successfully")
-            
+            if current:
+                try:
+                    response = self.api.task(current[1], 'heartbeat', {})
+                    if response.get('cancel_requested'):
+                        self.cancel_requested.add(current[1])
+                except StaleMessage:
+                    pass
+                except Exception as e:
+                    logger.error(f"Heartbeat for message {current[1]} failed: {e}")
+    
+    def report(self, message: Dict[str, Any], action: str, body: Dict[str, Any]):
+        """Report a task outcome and delete its message once the server has
+        it. If the report fails the message is redelivered."""
+        try:
+            response = self.api.task(message['MessageId'], action, body)
+            logger.info(f"Message {message['MessageId']}: task is now {response.get('status')}")
+        except StaleMessage:
+            logger.info(f"Task for message {message['MessageId']} is no longer processing")
         except Exception as e:
-            logger.error(f"Error processing message: {e}")
-            self.fail_task(message.get('MessageId'), str(e))
+            logger.error(f"Error reporting {action} for message {message['MessageId']}: {e}")
+            return
+        self.delete_message(message)
     
-    def update_task_status(self, message_id: str, status: str):
-        """Update task status in database"""
-        with self.db_conn.cursor() as cursor:
-            cursor.execute("""
-                UPDATE tasks 
-                SET status = %s, worker_id = %s, started_at = CURRENT_TIMESTAMP
-                WHERE message_id = %s
-            """, (status, self.worker_id, message_id))
+    def dead_letter(self, message: Dict[str, Any], error: Exception):
+        """Record a message that cannot be parsed and delete it. If recording
+        fails the message is redelivered."""
+        message_id = message['MessageId']
+        logger.error(f"Dead-lettering malformed message {message_id}: {error}")
+        try:
+            self.api.task(message_id, 'dead-letter', {
+                'body': message['Body'],
+                'error': str(error),
+                'attempts': int(message.get('Attributes', {}).get('ApproximateReceiveCount', 0)),
+            })
+        except Exception as e:
+            logger.error(f"Error dead-lettering message {message_id}: {e}")
+            return
+        self.delete_message(message)
     
-    def complete_task(self, message_id: str, result: Dict[str, Any]):
-        """Mark task as completed with result"""
-        with self.db_conn.cursor() as cursor:
-            cursor.execute("""
-                UPDATE tasks 
-                SET status = 'completed', 
-                    result = %s,
-                    completed_at = CURRENT_TIMESTAMP
-                WHERE message_id = %s
-            """, (json.dumps(result), message_id))
+    def extend_visibility(self, message: Dict[str, Any], seconds: int):
+        """Hide a received message from other consumers for seconds"""
+        try:
+            self.sqs.change_message_visibility(
+                QueueUrl=self.queue_url,
+                ReceiptHandle=message['ReceiptHandle'],
+                VisibilityTimeout=min(seconds, MAX_VISIBILITY)
+            )
+        except Exception as e:
+            logger.error(f"Error extending visibility of message {message['MessageId']}: {e}")
     
-    def fail_task(self, message_id: str, error: str):
-        """Mark task as failed with error message"""
-        with self.db_conn.cursor() as cursor:
-            cursor.execute("""
-                UPDATE tasks 
-                SET status = 'failed', 
-                    error_message = %s,
-                    completed_at = CURRENT_TIMESTAMP
-                WHERE message_id = %s
-            """, (error, message_id))
+    def delete_message(self, message: Dict[str, Any]):
+        """Delete a message from the queue"""
+        self.sqs.delete_message(
+            QueueUrl=self.queue_url,
+            ReceiptHandle=message['ReceiptHandle']
+        )
     
     # Task handlers
     def handle_email_task(self, payload: Dict[str, Any]) -> Dict[str, Any]:
@@ -291,7 +439,8 @@ if __name__ == '__main__':
         'AWS_ACCESS_KEY_ID',
         'AWS_SECRET_ACCESS_KEY',
         'AWS_SQS_QUEUE_URL',
-        'DATABASE_URL'
+        'API_URL',
+        'WORKER_TOKEN'
     ]
     
     missing = [var for var in required_env if not os.getenv(var)]